@@ -0,0 +1,184 @@
+// Package api is a client for the Stockfighter order book API.
+package api
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "strings"
+)
+
+const DefaultBaseUrl = "https://api.stockfighter.io/ob/api"
+
+// Error is returned when the venue answers with "ok": false.
+type Error struct {
+    Method     string
+    Path       string
+    StatusCode int
+    Message    string
+}
+
+func (e *Error) Error() string {
+    return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the venue failed on its side, in which case the
+// same request may succeed later.
+func (e *Error) Temporary() bool {
+    return e.StatusCode >= 500
+}
+
+// Rejected reports whether the venue turned an order down: placing it, on
+// its own or to amend another, was answered with a 400 or a 422. The same
+// order will not do any better sent again, but the next one may.
+func (e *Error) Rejected() bool {
+    return e.Method == "POST" &&
+        (e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity)
+}
+
+type Client struct {
+    BaseUrl    string
+    ApiKey     string
+    HttpClient *http.Client
+    // Spaces the requests when set, see Limiter.
+    Limiter *Limiter
+}
+
+func NewClient(apiKey string) *Client {
+    return &Client{
+        BaseUrl:    DefaultBaseUrl,
+        ApiKey:     apiKey,
+        HttpClient: &http.Client{},
+    }
+}
+
+func (c *Client) do(method string, path string, body interface{}, out interface{}) error {
+    var reader io.Reader
+    if body != nil {
+        jsonBody, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        reader = bytes.NewReader(jsonBody)
+    }
+
+    httpRequest, err := http.NewRequest(method, strings.TrimRight(c.BaseUrl, "/")+path, reader)
+    if err != nil {
+        return err
+    }
+    if c.ApiKey != "" {
+        httpRequest.Header.Add("X-Starfighter-Authorization", c.ApiKey)
+    }
+    if body != nil {
+        httpRequest.Header.Set("Content-Type", "application/json")
+    }
+
+    httpClient := c.HttpClient
+    if httpClient == nil {
+        httpClient = http.DefaultClient
+    }
+    if c.Limiter != nil {
+        done := c.Limiter.Wait(endpoint(method, path))
+        defer done()
+    }
+    httpResponse, err := httpClient.Do(httpRequest)
+    if err != nil {
+        return err
+    }
+    defer httpResponse.Body.Close()
+    if httpResponse.StatusCode == http.StatusTooManyRequests && c.Limiter != nil {
+        c.Limiter.Throttled(endpoint(method, path))
+    }
+
+    responseData, err := ioutil.ReadAll(httpResponse.Body)
+    if err != nil {
+        return err
+    }
+
+    var status struct {
+        Ok    bool   `json:"ok"`
+        Error string `json:"error"`
+    }
+    if err = json.Unmarshal(responseData, &status); err != nil {
+        return fmt.Errorf("%s %s: %d %v: %q", method, path, httpResponse.StatusCode, err, responseData)
+    }
+    if !status.Ok {
+        return &Error{
+            Method:     method,
+            Path:       path,
+            StatusCode: httpResponse.StatusCode,
+            Message:    status.Error,
+        }
+    }
+    if out == nil {
+        return nil
+    }
+    return json.Unmarshal(responseData, out)
+}
+
+func (c *Client) Heartbeat() error {
+    return c.do("GET", "/heartbeat", nil, nil)
+}
+
+func (c *Client) CheckVenue(venue string) error {
+    return c.do("GET", fmt.Sprintf("/venues/%s/heartbeat", venue), nil, nil)
+}
+
+func (c *Client) Stocks(venue string) ([]Symbol, error) {
+    var response struct {
+        Symbols []Symbol `json:"symbols"`
+    }
+    err := c.do("GET", fmt.Sprintf("/venues/%s/stocks", venue), nil, &response)
+    return response.Symbols, err
+}
+
+func (c *Client) Quote(venue string, stock string) (StockQuote, error) {
+    var quote StockQuote
+    err := c.do("GET", fmt.Sprintf("/venues/%s/stocks/%s/quote", venue, stock), nil, &quote)
+    return quote, err
+}
+
+func (c *Client) OrderBook(venue string, stock string) (OrderBook, error) {
+    var book OrderBook
+    err := c.do("GET", fmt.Sprintf("/venues/%s/stocks/%s", venue, stock), nil, &book)
+    return book, err
+}
+
+// PlaceOrder sends request, unless it fails validation, in which case it
+// is turned down as the venue would with a 400.
+func (c *Client) PlaceOrder(request OrderRequest) (Order, error) {
+    var order Order
+    path := fmt.Sprintf("/venues/%s/stocks/%s/orders", request.Venue, request.Stock)
+    if err := request.Validate(); err != nil {
+        return order, &Error{Method: "POST", Path: path, StatusCode: http.StatusBadRequest, Message: err.Error()}
+    }
+    err := c.do("POST", path, request, &order)
+    return order, err
+}
+
+func (c *Client) OrderStatus(venue string, stock string, id int) (Order, error) {
+    var order Order
+    err := c.do("GET", fmt.Sprintf("/venues/%s/stocks/%s/orders/%d", venue, stock, id), nil, &order)
+    return order, err
+}
+
+func (c *Client) CancelOrder(venue string, stock string, id int) (Order, error) {
+    var order Order
+    err := c.do("DELETE", fmt.Sprintf("/venues/%s/stocks/%s/orders/%d", venue, stock, id), nil, &order)
+    return order, err
+}
+
+// AllOrders returns every order of the account for the given stock. An
+// empty stock lists the orders across the whole venue.
+func (c *Client) AllOrders(account string, venue string, stock string) ([]Order, error) {
+    var response AllOrders
+    path := fmt.Sprintf("/venues/%s/accounts/%s/orders", venue, account)
+    if stock != "" {
+        path = fmt.Sprintf("/venues/%s/accounts/%s/stocks/%s/orders", venue, account, stock)
+    }
+    err := c.do("GET", path, nil, &response)
+    return response.Orders, err
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+    "fmt"
+    "math/rand"
+    "net"
+    "strings"
+    "sync"
+    "time"
+
+    "golang.org/x/net/websocket"
+)
+
+const (
+    DefaultMinBackoff = 250 * time.Millisecond
+    DefaultMaxBackoff = 30 * time.Second
+    // The tickertape sends a quote whenever the book changes: silent this
+    // long, it is taken for dead.
+    DefaultTickertapeSilence = 30 * time.Second
+)
+
+// Gap describes an interruption of a feed. It is delivered once the feed
+// is back up, ahead of the first message received after it.
+type Gap struct {
+    Feed     string
+    Err      error
+    Since    time.Time
+    Until    time.Time
+    Attempts int
+}
+
+func (g Gap) Duration() time.Duration {
+    return g.Until.Sub(g.Since)
+}
+
+// Feed keeps a websocket subscription alive, redialing with exponential
+// backoff and jitter whenever the venue drops it.
+type Feed struct {
+    Name       string
+    MinBackoff time.Duration
+    MaxBackoff time.Duration
+    // Longest the feed may go without a message before the connection is
+    // dropped and redialed, 0 to wait forever. A half-open socket never
+    // fails a read, only silence gives it away.
+    Silence time.Duration
+
+    dial    func() (*websocket.Conn, error)
+    receive func(*websocket.Conn) (interface{}, error)
+
+    mu     sync.Mutex
+    conn   *websocket.Conn
+    done   chan struct{}
+    closed bool
+}
+
+func newFeed(name string, dial func() (*websocket.Conn, error), receive func(*websocket.Conn) (interface{}, error)) *Feed {
+    return &Feed{
+        Name:       name,
+        MinBackoff: DefaultMinBackoff,
+        MaxBackoff: DefaultMaxBackoff,
+        dial:       dial,
+        receive:    receive,
+        done:       make(chan struct{}),
+    }
+}
+
+// TickertapeFeed delivers StockQuoteWs messages.
+func (c *Client) TickertapeFeed(account string, venue string, stock string) *Feed {
+    name := strings.TrimSpace("tickertape " + venue + " " + stock)
+    feed := newFeed(name,
+        func() (*websocket.Conn, error) {
+            return c.DialTickertape(account, venue, stock)
+        },
+        func(ws *websocket.Conn) (interface{}, error) {
+            var quote StockQuoteWs
+            err := websocket.JSON.Receive(ws, &quote)
+            return quote, err
+        })
+    feed.Silence = DefaultTickertapeSilence
+    return feed
+}
+
+// ExecutionsFeed delivers Executions messages.
+func (c *Client) ExecutionsFeed(account string, venue string, stock string) *Feed {
+    name := strings.TrimSpace("executions " + venue + " " + stock)
+    return newFeed(name,
+        func() (*websocket.Conn, error) {
+            return c.DialExecutions(account, venue, stock)
+        },
+        func(ws *websocket.Conn) (interface{}, error) {
+            var execution Executions
+            err := websocket.JSON.Receive(ws, &execution)
+            return execution, err
+        })
+}
+
+// Connect dials the feed once, so that a bad venue or account is reported
+// at startup instead of being retried forever by Run.
+func (f *Feed) Connect() error {
+    ws, err := f.dial()
+    if err != nil {
+        return err
+    }
+    if !f.setConn(ws) {
+        ws.Close()
+    }
+    return nil
+}
+
+func (f *Feed) setConn(ws *websocket.Conn) bool {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if f.closed {
+        return false
+    }
+    f.conn = ws
+    return true
+}
+
+func (f *Feed) current() *websocket.Conn {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return f.conn
+}
+
+// Up reports whether the feed currently has a live connection.
+func (f *Feed) Up() bool {
+    return f.current() != nil
+}
+
+// Run delivers the feed's messages and Gaps to out until Close is called,
+// reconnecting as often as needed. It closes out when it returns.
+func (f *Feed) Run(out chan<- interface{}) {
+    defer close(out)
+
+    var gap *Gap
+    for {
+        ws := f.current()
+        if ws == nil {
+            if gap == nil {
+                gap = &Gap{Feed: f.Name, Since: time.Now()}
+            }
+            ws = f.reconnect(gap)
+            if ws == nil || !f.deliver(out, *gap) {
+                return
+            }
+            gap = nil
+        }
+
+        if f.Silence > 0 {
+            ws.SetReadDeadline(time.Now().Add(f.Silence))
+        }
+        message, err := f.receive(ws)
+        if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+            err = fmt.Errorf("no message for %s: %v", f.Silence, err)
+        }
+        if err != nil {
+            ws.Close()
+            f.mu.Lock()
+            f.conn = nil
+            closed := f.closed
+            f.mu.Unlock()
+            if closed {
+                return
+            }
+            gap = &Gap{Feed: f.Name, Err: err, Since: time.Now()}
+            continue
+        }
+        if !f.deliver(out, message) {
+            return
+        }
+    }
+}
+
+// deliver hands message over unless the feed gets closed while waiting for
+// the reader.
+func (f *Feed) deliver(out chan<- interface{}, message interface{}) bool {
+    select {
+    case out <- message:
+        return true
+    case <-f.done:
+        return false
+    }
+}
+
+// reconnect dials until it succeeds or the feed is closed, recording the
+// attempts in gap.
+func (f *Feed) reconnect(gap *Gap) *websocket.Conn {
+    backoff := f.MinBackoff
+    for {
+        gap.Attempts++
+        ws, err := f.dial()
+        if err == nil {
+            if !f.setConn(ws) {
+                ws.Close()
+                return nil
+            }
+            gap.Until = time.Now()
+            return ws
+        }
+
+        // Sleep somewhere between half and all of the backoff so that
+        // several feeds dropped together do not redial in lockstep.
+        sleep := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+        select {
+        case <-f.done:
+            return nil
+        case <-time.After(sleep):
+        }
+        backoff *= 2
+        if backoff > f.MaxBackoff {
+            backoff = f.MaxBackoff
+        }
+    }
+}
+
+func (f *Feed) Close() error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if f.closed {
+        return nil
+    }
+    f.closed = true
+    close(f.done)
+    if f.conn != nil {
+        return f.conn.Close()
+    }
+    return nil
+}
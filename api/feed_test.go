@@ -0,0 +1,56 @@
+package api
+
+import (
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "golang.org/x/net/websocket"
+)
+
+// A connection the venue stopped writing to, without closing it, is
+// redialed once silent for longer than the feed allows.
+func TestSilentFeedReconnects(t *testing.T) {
+    release := make(chan struct{})
+    defer close(release)
+    server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+        <-release
+    }))
+    defer server.Close()
+
+    url := "ws" + strings.TrimPrefix(server.URL, "http")
+    feed := newFeed("silent",
+        func() (*websocket.Conn, error) {
+            return websocket.Dial(url, "", "http://localhost/")
+        },
+        func(ws *websocket.Conn) (interface{}, error) {
+            var quote StockQuoteWs
+            err := websocket.JSON.Receive(ws, &quote)
+            return quote, err
+        })
+    feed.MinBackoff = time.Millisecond
+    feed.Silence = 200 * time.Millisecond
+    if err := feed.Connect(); err != nil {
+        t.Fatal(err)
+    }
+    defer feed.Close()
+
+    out := make(chan interface{})
+    go feed.Run(out)
+    select {
+    case message := <-out:
+        gap, ok := message.(Gap)
+        if !ok {
+            t.Fatalf("expected a gap, got %#v", message)
+        }
+        if gap.Err == nil || !strings.Contains(gap.Err.Error(), "no message for") {
+            t.Fatalf("expected the gap to blame the silence, got %v", gap.Err)
+        }
+        if !feed.Up() {
+            t.Fatal("expected the feed to be up again")
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("silent feed never reconnected")
+    }
+}
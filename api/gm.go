@@ -0,0 +1,99 @@
+package api
+
+import (
+    "fmt"
+    "net/http"
+)
+
+const DefaultGmUrl = "https://www.stockfighter.io/gm"
+
+// Level is what the game master answers when a level is started, restarted
+// or resumed: where and with which account to trade.
+type Level struct {
+    Ok                   bool              `json:"ok"`
+    Account              string            `json:"account"`
+    InstanceId           int               `json:"instanceId"`
+    Instructions         map[string]string `json:"instructions"`
+    Tickers              []string          `json:"tickers"`
+    Venues               []string          `json:"venues"`
+    SecondsPerTradingDay int               `json:"secondsPerTradingDay"`
+    Balances             map[string]int    `json:"balances"`
+}
+
+type InstanceDetails struct {
+    EndOfTheWorldDay int `json:"endOfTheWorldDay"`
+    TradingDay       int `json:"tradingDay"`
+}
+
+// Flash holds the messages the game master shows about the level, such as
+// the objective being met or failed.
+type Flash struct {
+    Info    string `json:"info"`
+    Success string `json:"success"`
+    Warning string `json:"warning"`
+    Danger  string `json:"danger"`
+}
+
+type Instance struct {
+    Ok      bool            `json:"ok"`
+    Done    bool            `json:"done"`
+    Id      int             `json:"id"`
+    State   string          `json:"state"`
+    Details InstanceDetails `json:"details"`
+    Flash   Flash           `json:"flash"`
+}
+
+// EndOfDays tells whether the level ran out of trading days.
+func (i Instance) EndOfDays() bool {
+    return i.Details.EndOfTheWorldDay > 0 && i.Details.TradingDay >= i.Details.EndOfTheWorldDay
+}
+
+// GameMaster is a client for the API that starts and stops level instances.
+type GameMaster struct {
+    BaseUrl    string
+    ApiKey     string
+    HttpClient *http.Client
+}
+
+func NewGameMaster(apiKey string) *GameMaster {
+    return &GameMaster{
+        BaseUrl:    DefaultGmUrl,
+        ApiKey:     apiKey,
+        HttpClient: &http.Client{},
+    }
+}
+
+func (g *GameMaster) do(method string, path string, out interface{}) error {
+    client := Client{BaseUrl: g.BaseUrl, ApiKey: g.ApiKey, HttpClient: g.HttpClient}
+    return client.do(method, path, nil, out)
+}
+
+// StartLevel starts the named level, e.g. "first_steps", or returns the
+// instance already running for it.
+func (g *GameMaster) StartLevel(name string) (Level, error) {
+    var level Level
+    err := g.do("POST", fmt.Sprintf("/levels/%s", name), &level)
+    return level, err
+}
+
+func (g *GameMaster) Restart(instanceId int) (Level, error) {
+    var level Level
+    err := g.do("POST", fmt.Sprintf("/instances/%d/restart", instanceId), &level)
+    return level, err
+}
+
+func (g *GameMaster) Stop(instanceId int) error {
+    return g.do("POST", fmt.Sprintf("/instances/%d/stop", instanceId), nil)
+}
+
+func (g *GameMaster) Resume(instanceId int) (Level, error) {
+    var level Level
+    err := g.do("POST", fmt.Sprintf("/instances/%d/resume", instanceId), &level)
+    return level, err
+}
+
+func (g *GameMaster) Instance(instanceId int) (Instance, error) {
+    var instance Instance
+    err := g.do("GET", fmt.Sprintf("/instances/%d", instanceId), &instance)
+    return instance, err
+}
@@ -0,0 +1,310 @@
+package api
+
+import (
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Endpoint is a class of requests sharing a budget.
+type Endpoint string
+
+const (
+    EndpointOrder  Endpoint = "order"
+    EndpointCancel Endpoint = "cancel"
+    // Status of one order, or of every order of the account.
+    EndpointStatus Endpoint = "status"
+    // Quotes, books, stock lists and heartbeats.
+    EndpointMarket Endpoint = "market"
+)
+
+// endpoint classifies a request by its method and path.
+func endpoint(method string, path string) Endpoint {
+    switch {
+    case method == "DELETE" || strings.HasSuffix(path, "/cancel"):
+        return EndpointCancel
+    case method == "POST" && strings.HasSuffix(path, "/orders"):
+        return EndpointOrder
+    case strings.Contains(path, "/orders"):
+        return EndpointStatus
+    }
+    return EndpointMarket
+}
+
+// priority orders the queue: cancels go first, as an order left working
+// costs more than one sent late.
+func (e Endpoint) priority() int {
+    if e == EndpointCancel {
+        return 0
+    }
+    return 1
+}
+
+// Budget is a token bucket: Rate requests a second on average, up to Burst
+// at once. A zero rate is not limited.
+type Budget struct {
+    Rate  float64 `json:"rate"`
+    Burst int     `json:"burst"`
+}
+
+// RateLimits bound the requests a client sends, all endpoints together and
+// per endpoint.
+type RateLimits struct {
+    Total     Budget              `json:"total"`
+    Endpoints map[Endpoint]Budget `json:"endpoints"`
+    // Requests in flight at once, 0 for no limit.
+    Concurrency int `json:"concurrency"`
+}
+
+// DefaultRateLimits keep below what the venues throttle at. Cancels only
+// count against the total.
+func DefaultRateLimits() RateLimits {
+    return RateLimits{
+        Total: Budget{Rate: 10, Burst: 10},
+        Endpoints: map[Endpoint]Budget{
+            EndpointOrder:  {Rate: 5, Burst: 5},
+            EndpointStatus: {Rate: 5, Burst: 5},
+            EndpointMarket: {Rate: 5, Burst: 5},
+        },
+        Concurrency: 8,
+    }
+}
+
+type bucket struct {
+    budget Budget
+    tokens float64
+    at     time.Time
+}
+
+func newBucket(budget Budget, now time.Time) *bucket {
+    if budget.Burst < 1 {
+        budget.Burst = 1
+    }
+    return &bucket{budget: budget, tokens: float64(budget.Burst), at: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+    if b == nil || b.budget.Rate <= 0 {
+        return
+    }
+    b.tokens += now.Sub(b.at).Seconds() * b.budget.Rate
+    if burst := float64(b.budget.Burst); b.tokens > burst {
+        b.tokens = burst
+    }
+    b.at = now
+}
+
+func (b *bucket) ready() bool {
+    return b == nil || b.budget.Rate <= 0 || b.tokens >= 1
+}
+
+func (b *bucket) take() {
+    if b != nil && b.budget.Rate > 0 {
+        b.tokens -= 1
+    }
+}
+
+// until is how long before the bucket holds a token.
+func (b *bucket) until() time.Duration {
+    if b.ready() {
+        return 0
+    }
+    return time.Duration((1 - b.tokens) / b.budget.Rate * float64(time.Second))
+}
+
+// EndpointStats are the queueing metrics of an endpoint.
+type EndpointStats struct {
+    Requests int
+    // Requests that had to wait for their turn.
+    Queued    int
+    TotalWait time.Duration
+    MaxWait   time.Duration
+    // Requests waiting now, and the most there ever were.
+    Waiting    int
+    MaxWaiting int
+}
+
+func (s EndpointStats) AverageWait() time.Duration {
+    if s.Requests == 0 {
+        return 0
+    }
+    return s.TotalWait / time.Duration(s.Requests)
+}
+
+type waiter struct {
+    endpoint Endpoint
+}
+
+// Limiter spaces the requests of a client so they stay within their rate
+// limits. It is shared by every goroutine using the client: requests over
+// budget queue, cancels ahead of the rest, and are served in the order they
+// came as soon as their budgets allow.
+type Limiter struct {
+    limits RateLimits
+
+    mu       sync.Mutex
+    total    *bucket
+    buckets  map[Endpoint]*bucket
+    queue    []*waiter
+    inFlight int
+    // Closed and replaced whenever a waiter may have become eligible.
+    wake  chan struct{}
+    stats map[Endpoint]*EndpointStats
+}
+
+func NewLimiter(limits RateLimits) *Limiter {
+    now := time.Now()
+    l := &Limiter{
+        limits:  limits,
+        total:   newBucket(limits.Total, now),
+        buckets: map[Endpoint]*bucket{},
+        wake:    make(chan struct{}),
+        stats:   map[Endpoint]*EndpointStats{},
+    }
+    for endpoint, budget := range limits.Endpoints {
+        l.buckets[endpoint] = newBucket(budget, now)
+    }
+    return l
+}
+
+func (l *Limiter) endpointStats(endpoint Endpoint) *EndpointStats {
+    stats, ok := l.stats[endpoint]
+    if !ok {
+        stats = &EndpointStats{}
+        l.stats[endpoint] = stats
+    }
+    return stats
+}
+
+func (l *Limiter) broadcast() {
+    close(l.wake)
+    l.wake = make(chan struct{})
+}
+
+// eligible tells whether w may be sent now: budgets allow it and no waiter
+// ahead of it in the queue could be sent instead.
+func (l *Limiter) eligible(w *waiter) bool {
+    if !l.total.ready() || (l.limits.Concurrency > 0 && l.inFlight >= l.limits.Concurrency) {
+        return false
+    }
+    for _, ahead := range l.queue {
+        if ahead == w {
+            return l.buckets[w.endpoint].ready()
+        }
+        if l.buckets[ahead.endpoint].ready() {
+            return false
+        }
+    }
+    return false
+}
+
+func (l *Limiter) enqueue(w *waiter) {
+    l.queue = append(l.queue, w)
+    sort.SliceStable(l.queue, func(i, j int) bool {
+        return l.queue[i].endpoint.priority() < l.queue[j].endpoint.priority()
+    })
+}
+
+func (l *Limiter) dequeue(w *waiter) {
+    for i, queued := range l.queue {
+        if queued == w {
+            l.queue = append(l.queue[:i], l.queue[i+1:]...)
+            return
+        }
+    }
+}
+
+// Wait blocks until a request to endpoint may be sent. The returned
+// function must be called once the response is in.
+func (l *Limiter) Wait(endpoint Endpoint) func() {
+    start := time.Now()
+    l.mu.Lock()
+    w := &waiter{endpoint: endpoint}
+    l.enqueue(w)
+    stats := l.endpointStats(endpoint)
+    stats.Waiting += 1
+    if stats.Waiting > stats.MaxWaiting {
+        stats.MaxWaiting = stats.Waiting
+    }
+
+    for {
+        now := time.Now()
+        l.total.refill(now)
+        for _, b := range l.buckets {
+            b.refill(now)
+        }
+        if l.eligible(w) {
+            break
+        }
+        // Tokens come back with time; a slot in flight or a place in the
+        // queue when another request leaves, which wakes the waiters.
+        delay := l.total.until()
+        if own := l.buckets[endpoint].until(); own > delay {
+            delay = own
+        }
+        var timeout <-chan time.Time
+        if delay > 0 {
+            timeout = time.After(delay)
+        }
+        wake := l.wake
+        l.mu.Unlock()
+        select {
+        case <-wake:
+        case <-timeout:
+        }
+        l.mu.Lock()
+    }
+
+    l.total.take()
+    l.buckets[endpoint].take()
+    l.dequeue(w)
+    l.inFlight += 1
+    wait := time.Since(start)
+    stats.Waiting -= 1
+    stats.Requests += 1
+    stats.TotalWait += wait
+    if wait > stats.MaxWait {
+        stats.MaxWait = wait
+    }
+    if wait >= time.Millisecond {
+        stats.Queued += 1
+    }
+    l.broadcast()
+    l.mu.Unlock()
+
+    var once sync.Once
+    return func() {
+        once.Do(func() {
+            l.mu.Lock()
+            l.inFlight -= 1
+            l.broadcast()
+            l.mu.Unlock()
+        })
+    }
+}
+
+// Throttled empties the buckets of endpoint after the venue answered that
+// the client goes too fast, so the next requests wait for fresh tokens.
+func (l *Limiter) Throttled(endpoint Endpoint) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    now := time.Now()
+    for _, b := range []*bucket{l.total, l.buckets[endpoint]} {
+        if b != nil && b.budget.Rate > 0 {
+            b.refill(now)
+            b.tokens = 0
+        }
+    }
+}
+
+// Stats returns the queueing metrics of every endpoint used so far.
+func (l *Limiter) Stats() map[Endpoint]EndpointStats {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    stats := make(map[Endpoint]EndpointStats, len(l.stats))
+    for endpoint, s := range l.stats {
+        stats[endpoint] = *s
+    }
+    return stats
+}
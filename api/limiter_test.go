@@ -0,0 +1,91 @@
+package api
+
+import (
+    "sync"
+    "testing"
+    "time"
+)
+
+// send has the limiter let n requests to endpoint through, one after the
+// other, and returns how long that took.
+func send(l *Limiter, endpoint Endpoint, n int) time.Duration {
+    start := time.Now()
+    for i := 0; i < n; i++ {
+        l.Wait(endpoint)()
+    }
+    return time.Since(start)
+}
+
+// Requests are spaced by the total budget and by that of their endpoint,
+// whichever is tighter.
+func TestLimiterSpacing(t *testing.T) {
+    l := NewLimiter(RateLimits{
+        Total:     Budget{Rate: 50, Burst: 1},
+        Endpoints: map[Endpoint]Budget{EndpointOrder: {Rate: 20, Burst: 1}},
+    })
+    // 20ms apart under the total budget, the market having none of its own.
+    if took := send(l, EndpointMarket, 6); took < 90*time.Millisecond {
+        t.Fatalf("6 market requests took %s, expected at least 100ms", took)
+    }
+    // 50ms apart under the budget of orders.
+    if took := send(l, EndpointOrder, 5); took < 180*time.Millisecond {
+        t.Fatalf("5 orders took %s, expected at least 200ms", took)
+    }
+    stats := l.Stats()
+    if orders := stats[EndpointOrder]; orders.Requests != 5 || orders.Queued < 4 {
+        t.Fatalf("expected 5 orders, 4 of them queued, got %+v", orders)
+    }
+}
+
+// A cancel sent while orders are queued goes ahead of them.
+func TestLimiterCancelFirst(t *testing.T) {
+    l := NewLimiter(RateLimits{Concurrency: 1})
+    // Keeps every other request queued until done.
+    done := l.Wait(EndpointStatus)
+
+    var wg sync.WaitGroup
+    sent := make(chan Endpoint, 4)
+    queue := func(endpoint Endpoint, queued int) {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            release := l.Wait(endpoint)
+            sent <- endpoint
+            release()
+        }()
+        for l.Stats()[endpoint].Waiting < queued {
+            time.Sleep(time.Millisecond)
+        }
+    }
+    for i := 1; i <= 3; i++ {
+        queue(EndpointOrder, i)
+    }
+    queue(EndpointCancel, 1)
+
+    done()
+    wg.Wait()
+    close(sent)
+    var order []Endpoint
+    for endpoint := range sent {
+        order = append(order, endpoint)
+    }
+    if len(order) != 4 || order[0] != EndpointCancel {
+        t.Fatalf("expected the cancel sent first, got %v", order)
+    }
+}
+
+// Once the venue throttled the client, the next request waits for a fresh
+// token even though the budget had some left.
+func TestLimiterThrottled(t *testing.T) {
+    l := NewLimiter(RateLimits{
+        Total:     Budget{Rate: 10, Burst: 10},
+        Endpoints: map[Endpoint]Budget{EndpointOrder: {Rate: 10, Burst: 10}},
+    })
+    if took := send(l, EndpointOrder, 1); took > 50*time.Millisecond {
+        t.Fatalf("the first order took %s, expected it sent at once", took)
+    }
+    l.Throttled(EndpointOrder)
+    if took := send(l, EndpointOrder, 1); took < 80*time.Millisecond {
+        t.Fatalf("the order after throttling took %s, expected about 100ms", took)
+    }
+}
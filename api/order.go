@@ -0,0 +1,105 @@
+package api
+
+import (
+    "fmt"
+)
+
+// Direction is the side of an order.
+type Direction string
+
+const (
+    Buy  Direction = "buy"
+    Sell Direction = "sell"
+)
+
+func (d Direction) Valid() bool {
+    return d == Buy || d == Sell
+}
+
+// Opposite is the direction that trades against d.
+func (d Direction) Opposite() Direction {
+    if d == Buy {
+        return Sell
+    }
+    return Buy
+}
+
+// OrderType is one of the order types the venues accept, spelled exactly as
+// they expect it.
+type OrderType string
+
+const (
+    // Rests on the book at its price until filled or cancelled.
+    Limit OrderType = "limit"
+    // Trades against the book at any price, never rests.
+    Market OrderType = "market"
+    // Fills entirely at its price or better at once, or not at all.
+    FillOrKill OrderType = "fill-or-kill"
+    // Fills what it can at its price or better at once, the rest is
+    // cancelled.
+    ImmediateOrCancel OrderType = "immediate-or-cancel"
+)
+
+func (t OrderType) Valid() bool {
+    switch t {
+    case Limit, Market, FillOrKill, ImmediateOrCancel:
+        return true
+    }
+    return false
+}
+
+// Immediate tells whether orders of type t never rest on the book: the
+// venue answers them closed, with whatever they filled.
+func (t OrderType) Immediate() bool {
+    return t != Limit
+}
+
+// Priced tells whether orders of type t need a price.
+func (t OrderType) Priced() bool {
+    return t != Market
+}
+
+// OrderRequest is the body of a new order.
+type OrderRequest struct {
+    Account   string    `json:"account"`
+    Venue     string    `json:"venue"`
+    Stock     string    `json:"stock"`
+    Price     int       `json:"price"`
+    Qty       int       `json:"qty"`
+    Direction Direction `json:"direction"`
+    OrderType OrderType `json:"orderType"`
+}
+
+// Validate checks the request the way the venue would, so a bad order is
+// turned down before it is sent.
+func (r OrderRequest) Validate() error {
+    switch {
+    case r.Account == "" || r.Venue == "" || r.Stock == "":
+        return fmt.Errorf("account, venue and stock are required")
+    case !r.Direction.Valid():
+        return fmt.Errorf("invalid direction %q, expected buy or sell", r.Direction)
+    case !r.OrderType.Valid():
+        return fmt.Errorf("invalid order type %q, expected limit, market, fill-or-kill or immediate-or-cancel", r.OrderType)
+    case r.Qty <= 0:
+        return fmt.Errorf("qty must be positive, got %d", r.Qty)
+    case r.Price < 0:
+        return fmt.Errorf("price must be non-negative, got %d", r.Price)
+    case r.Price == 0 && r.OrderType.Priced():
+        return fmt.Errorf("%s order without a price", r.OrderType)
+    }
+    return nil
+}
+
+// Order is the order the request asks for, before the venue saw it.
+func (r OrderRequest) Order() Order {
+    return Order{
+        Symbol:      r.Stock,
+        Venue:       r.Venue,
+        Direction:   r.Direction,
+        OriginalQty: r.Qty,
+        Qty:         r.Qty,
+        Price:       r.Price,
+        OrderType:   r.OrderType,
+        Account:     r.Account,
+    }
+}
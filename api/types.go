@@ -0,0 +1,95 @@
+package api
+
+// Quote is the top of book for a single symbol as reported by the venue.
+type Quote struct {
+    Symbol    string `json:"symbol"`
+    Venue     string `json:"venue"`
+    Bid       int    `json:"bid"`
+    Ask       int    `json:"ask"`
+    BidSize   int    `json:"bidSize"`
+    AskSize   int    `json:"askSize"`
+    BidDepth  int    `json:"bidDepth"`
+    AskDepth  int    `json:"askDepth"`
+    Last      int    `json:"last"`
+    LastSize  int    `json:"lastSize"`
+    LastTrade string `json:"lastTrade"`
+    QuoteTime string `json:"quoteTime"`
+}
+
+// StockQuote is the response of the REST quote endpoint.
+type StockQuote struct {
+    Ok bool `json:"ok"`
+    Quote
+}
+
+// StockQuoteWs is a single tickertape message.
+type StockQuoteWs struct {
+    Ok    bool  `json:"ok"`
+    Quote Quote `json:"quote"`
+}
+
+type BookEntry struct {
+    Price int  `json:"price"`
+    Qty   int  `json:"qty"`
+    IsBuy bool `json:"isBuy"`
+}
+
+type OrderBook struct {
+    Ok     bool        `json:"ok"`
+    Venue  string      `json:"venue"`
+    Symbol string      `json:"symbol"`
+    Bids   []BookEntry `json:"bids"`
+    Asks   []BookEntry `json:"asks"`
+    Ts     string      `json:"ts"`
+}
+
+type Fill struct {
+    Price int    `json:"price"`
+    Qty   int    `json:"qty"`
+    Ts    string `json:"ts"`
+}
+
+type Order struct {
+    Ok          bool      `json:"ok"`
+    Symbol      string    `json:"symbol"`
+    Venue       string    `json:"venue"`
+    Direction   Direction `json:"direction"`
+    OriginalQty int       `json:"originalQty"`
+    Qty         int       `json:"qty"`
+    Price       int       `json:"price"`
+    OrderType   OrderType `json:"orderType"`
+    Id          int       `json:"id"`
+    Account     string    `json:"account"`
+    Ts          string    `json:"ts"`
+    Fills       []Fill    `json:"fills"`
+    TotalFilled int       `json:"totalFilled"`
+    Open        bool      `json:"open"`
+}
+
+type AllOrders struct {
+    Ok     bool    `json:"ok"`
+    Venue  string  `json:"venue"`
+    Orders []Order `json:"orders"`
+}
+
+// Executions is a single message of the executions websocket, sent for
+// every fill involving one of the account's orders.
+type Executions struct {
+    Ok               bool   `json:"ok"`
+    Account          string `json:"account"`
+    Venue            string `json:"venue"`
+    Symbol           string `json:"symbol"`
+    Order            Order  `json:"order"`
+    StandingId       int    `json:"standingId"`
+    IncomingId       int    `json:"incomingId"`
+    Price            int    `json:"price"`
+    Filled           int    `json:"filled"`
+    FilledAt         string `json:"filledAt"`
+    StandingComplete bool   `json:"standingComplete"`
+    IncomingComplete bool   `json:"incomingComplete"`
+}
+
+type Symbol struct {
+    Name   string `json:"name"`
+    Symbol string `json:"symbol"`
+}
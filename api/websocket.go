@@ -0,0 +1,42 @@
+package api
+
+import (
+    "fmt"
+    "strings"
+
+    "golang.org/x/net/websocket"
+)
+
+const wsOrigin = "http://localhost/"
+
+// WsUrl is the websocket root derived from BaseUrl, so that pointing the
+// client at another exchange moves the feeds along with the REST calls.
+func (c *Client) WsUrl() string {
+    base := strings.TrimRight(c.BaseUrl, "/")
+    if strings.HasPrefix(base, "https://") {
+        base = "wss://" + strings.TrimPrefix(base, "https://")
+    } else if strings.HasPrefix(base, "http://") {
+        base = "ws://" + strings.TrimPrefix(base, "http://")
+    }
+    return base + "/ws"
+}
+
+func (c *Client) feedUrl(feed string, account string, venue string, stock string) string {
+    url := fmt.Sprintf("%s/%s/venues/%s/%s", c.WsUrl(), account, venue, feed)
+    if stock != "" {
+        url += "/stocks/" + stock
+    }
+    return url
+}
+
+// DialTickertape opens the tickertape feed of a stock, or of the whole
+// venue when stock is empty.
+func (c *Client) DialTickertape(account string, venue string, stock string) (*websocket.Conn, error) {
+    return websocket.Dial(c.feedUrl("tickertape", account, venue, stock), "", wsOrigin)
+}
+
+// DialExecutions opens the executions feed of a stock, or of the whole
+// venue when stock is empty.
+func (c *Client) DialExecutions(account string, venue string, stock string) (*websocket.Conn, error) {
+    return websocket.Dial(c.feedUrl("executions", account, venue, stock), "", wsOrigin)
+}
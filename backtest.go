@@ -0,0 +1,442 @@
+package main
+
+import (
+    "encoding/csv"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+    "github.com/vincenzoauteri/stockfighter/recording"
+)
+
+// Backtest replays recordings through a strategy. Quotes and order books
+// reach it as they did live, while its orders go to a simulated venue that
+// fills them from the recorded market according to the FillModel. The
+// clock is the time messages were received, and OnTimer fires every
+// Interval of it.
+type Backtest struct {
+    Strategy  Strategy
+    FillModel FillModel
+    // Whether an order only fills up to the shares on offer, rather than
+    // for its whole remaining quantity.
+    Partial  bool
+    Interval time.Duration
+    Risk     RiskLimits
+
+    Report BacktestReport
+
+    risk      *risk_gateway
+    now       time.Time
+    nextTimer time.Time
+    nextId    int
+    working   []*sim_order
+    // Executions waiting for the strategy to return from the callback
+    // that caused them.
+    pending   []api.Executions
+    lastTrade map[Instrument]string
+    // Shares of the last trade of each market our orders did not take.
+    traded    map[Instrument]int
+}
+
+type InventoryPoint struct {
+    Time       time.Time
+    Instrument Instrument
+    Owned      int
+    Cash       int
+}
+
+type BacktestReport struct {
+    Start     time.Time
+    End       time.Time
+    Events    int
+    Orders    int
+    Cancels   int
+    Fills     int
+    FilledQty int
+    // Position after every fill.
+    Inventory []InventoryPoint
+    // NAV of the session at every timer and at the end.
+    Nav []NavPoint
+}
+
+// PnL is cash plus shares at the session's mark, in cents.
+func (r *BacktestReport) PnL() int {
+    return session_nav()
+}
+
+func (r *BacktestReport) CancelRatio() float64 {
+    if r.Orders == 0 {
+        return 0
+    }
+    return float64(r.Cancels) / float64(r.Orders)
+}
+
+func (r *BacktestReport) Print(w io.Writer) {
+    fmt.Fprintf(w, "Replayed %d events from %s to %s\n", r.Events, r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339))
+    fmt.Fprintf(w, "Orders %d, cancels %d (ratio %.2f), fills %d for %d shares\n",
+        r.Orders, r.Cancels, r.CancelRatio(), r.Fills, r.FilledQty)
+
+    for _, instrument := range session.Instruments {
+        min, max := 0, 0
+        for _, point := range r.Inventory {
+            if point.Instrument != instrument {
+                continue
+            }
+            if point.Owned < min {
+                min = point.Owned
+            }
+            if point.Owned > max {
+                max = point.Owned
+            }
+        }
+        market := session.Markets[instrument]
+        fmt.Fprintf(w, "%s owned %d (min %d, max %d) cash %.2f marked at %.2f (%s)\n", instrument,
+            market.Position.Owned, min, max, float64(market.Position.Balance)/100.0,
+            float64(market.Valuation.Price)/100.0, market.Valuation.Mark)
+    }
+    fmt.Fprintf(w, "PnL %.2f\n", float64(r.PnL())/100.0)
+}
+
+// WriteNav writes the NAV series as CSV.
+func (r *BacktestReport) WriteNav(w io.Writer) error {
+    out := csv.NewWriter(w)
+    out.Write([]string{"time", "nav", "stale"})
+    for _, point := range r.Nav {
+        out.Write([]string{point.Time.Format(time.RFC3339Nano), strconv.Itoa(point.NAV), strconv.FormatBool(point.Stale)})
+    }
+    out.Flush()
+    return out.Error()
+}
+
+// WriteInventory writes the inventory path as CSV.
+func (r *BacktestReport) WriteInventory(w io.Writer) error {
+    out := csv.NewWriter(w)
+    out.Write([]string{"time", "instrument", "owned", "cash"})
+    for _, point := range r.Inventory {
+        out.Write([]string{point.Time.Format(time.RFC3339Nano), point.Instrument.String(),
+            strconv.Itoa(point.Owned), strconv.Itoa(point.Cash)})
+    }
+    out.Flush()
+    return out.Error()
+}
+
+// recorded_instruments lists the instruments quoted in a recording, in the
+// order they first appear.
+func recorded_instruments(path string) ([]Instrument, error) {
+    reader, err := recording.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer reader.Close()
+
+    var instruments []Instrument
+    seen := make(map[Instrument]bool)
+    for {
+        _, message, err := reader.Next()
+        if err == io.EOF {
+            return instruments, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+        if quote, ok := message.(api.StockQuoteWs); ok {
+            instrument := Instrument{Venue: quote.Quote.Venue, Symbol: quote.Quote.Symbol}
+            if !seen[instrument] {
+                seen[instrument] = true
+                instruments = append(instruments, instrument)
+            }
+        }
+    }
+}
+
+// Run replays the recordings in order, on the session's instruments.
+func (b *Backtest) Run(paths []string) error {
+    if err := init_session(); err != nil {
+        return err
+    }
+    b.lastTrade = make(map[Instrument]string)
+    b.traded = make(map[Instrument]int)
+    b.risk = new_risk_gateway(sim_gateway{b}, b.Risk, b.clock)
+    if err := b.Strategy.Init(&Context{Session: &session, Orders: b.risk}); err != nil {
+        return err
+    }
+
+    for _, path := range paths {
+        if err := b.replay(path); err != nil {
+            return fmt.Errorf("%s: %v", path, err)
+        }
+    }
+    b.Report.Nav = append(b.Report.Nav, value_positions(b.now))
+    b.Report.End = b.now
+    return nil
+}
+
+func (b *Backtest) replay(path string) error {
+    reader, err := recording.Open(path)
+    if err != nil {
+        return err
+    }
+    defer reader.Close()
+
+    for {
+        received, message, err := reader.Next()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        if err := b.advance(received); err != nil {
+            return err
+        }
+        if err := b.handle(message); err != nil {
+            return err
+        }
+    }
+}
+
+func (b *Backtest) clock() time.Time {
+    return b.now
+}
+
+// advance moves the clock, firing the timers due until then.
+func (b *Backtest) advance(now time.Time) error {
+    if b.Report.Start.IsZero() {
+        b.Report.Start = now
+        b.nextTimer = now.Add(b.Interval)
+    }
+    for b.Interval > 0 && !b.nextTimer.After(now) {
+        b.now = b.nextTimer
+        b.Report.Nav = append(b.Report.Nav, value_positions(b.now))
+        if err := b.risk.start_day(b.now); err != nil {
+            return err
+        }
+        if err := b.Strategy.OnTimer(b.nextTimer); err != nil {
+            return err
+        }
+        if err := b.deliver(); err != nil {
+            return err
+        }
+        b.nextTimer = b.nextTimer.Add(b.Interval)
+    }
+    b.now = now
+    return nil
+}
+
+func (b *Backtest) handle(message interface{}) error {
+    switch message := message.(type) {
+    case api.StockQuoteWs:
+        market := update_quotes_ws(message, b.now)
+        if market == nil {
+            return nil
+        }
+        b.Report.Events += 1
+        b.match(market, message.Quote)
+        if err := b.deliver(); err != nil {
+            return err
+        }
+        if err := b.Strategy.OnQuote(market, message.Quote); err != nil {
+            return err
+        }
+    case api.OrderBook:
+        market := session.Market(message.Venue, message.Symbol)
+        if market == nil {
+            return nil
+        }
+        b.Report.Events += 1
+        update_order_book_history(market.Book, message)
+        if poller, ok := b.Strategy.(BookPoller); ok && poller.PollBooks() {
+            if err := b.Strategy.OnOrderBook(market, message); err != nil {
+                return err
+            }
+        }
+    }
+    // Recorded executions were the live bot's, not the strategy's.
+    return b.deliver()
+}
+
+// deliver hands the strategy the executions of its orders. Fills caused by
+// its own callbacks may queue more.
+func (b *Backtest) deliver() error {
+    for len(b.pending) > 0 {
+        execution := b.pending[0]
+        b.pending = b.pending[1:]
+        market := session.Market(execution.Venue, execution.Symbol)
+        if err := b.Strategy.OnExecution(market, execution); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// match fills the working orders of market against a new quote.
+func (b *Backtest) match(market *Market, quote api.Quote) {
+    b.traded[market.Instrument] = 0
+    if last, ok := b.lastTrade[market.Instrument]; ok && quote.LastTrade != last {
+        b.traded[market.Instrument] = quote.LastSize
+    }
+    b.lastTrade[market.Instrument] = quote.LastTrade
+
+    working := b.working[:0]
+    for _, order := range b.working {
+        if order.order.Venue == market.Venue && order.order.Symbol == market.Symbol {
+            b.fill(market, order, b.FillModel.Fill(order, market.Quotes.last, b.traded[market.Instrument]))
+        }
+        if order.order.Open {
+            working = append(working, order)
+        }
+    }
+    b.working = working
+}
+
+// ts is the time the simulated venue stamps on orders and fills: that of
+// the last quote of market, which strategies compare against.
+func (b *Backtest) ts(market *Market) string {
+    if market.Quotes.last.QuoteTime != "" {
+        return market.Quotes.last.QuoteTime
+    }
+    return b.now.UTC().Format(time.RFC3339Nano)
+}
+
+func (b *Backtest) fill(market *Market, order *sim_order, offer Offer) {
+    if offer.Qty <= 0 {
+        return
+    }
+    qty, price := offer.Qty, offer.Price
+    if !b.Partial || qty > order.order.Qty {
+        qty = order.order.Qty
+    }
+    b.take(market, order, offer, qty)
+    ts := b.ts(market)
+    order.order.Qty -= qty
+    order.order.TotalFilled += qty
+    order.order.Fills = append(order.order.Fills, api.Fill{Price: price, Qty: qty, Ts: ts})
+    order.order.Open = order.order.Qty > 0
+    oms_update(order.order)
+
+    fill_position(&market.Position, order.order.Direction, qty, price)
+    mark_position(market, b.now)
+    b.Report.Fills += 1
+    b.Report.FilledQty += qty
+    b.Report.Inventory = append(b.Report.Inventory, InventoryPoint{
+        Time:       b.now,
+        Instrument: market.Instrument,
+        Owned:      market.Position.Owned,
+        Cash:       market.Position.Balance,
+    })
+    b.pending = append(b.pending, api.Executions{
+        Ok:               true,
+        Account:          order.order.Account,
+        Venue:            order.order.Venue,
+        Symbol:           order.order.Symbol,
+        Order:            order.order,
+        StandingId:       order.order.Id,
+        IncomingId:       order.order.Id,
+        Price:            price,
+        Filled:           qty,
+        FilledAt:         ts,
+        StandingComplete: !order.order.Open,
+        IncomingComplete: !order.order.Open,
+    })
+}
+
+// take removes what an order filled from what the market offered.
+func (b *Backtest) take(market *Market, order *sim_order, offer Offer, qty int) {
+    if !offer.Crossed {
+        b.traded[market.Instrument] -= qty
+        if b.traded[market.Instrument] < 0 {
+            b.traded[market.Instrument] = 0
+        }
+        return
+    }
+    size := &market.Quotes.last.AskSize
+    if !order.buy() {
+        size = &market.Quotes.last.BidSize
+    }
+    *size -= qty
+    if *size < 0 {
+        *size = 0
+    }
+}
+
+func rejected(format string, args ...interface{}) error {
+    return &api.Error{Method: "POST", Path: "backtest", StatusCode: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// sim_gateway is the venue of a backtest.
+type sim_gateway struct {
+    b *Backtest
+}
+
+func (g sim_gateway) Place(market *Market, direction api.Direction, qty int, price int, orderType api.OrderType) (api.Order, error) {
+    b := g.b
+    request := api.OrderRequest{
+        Account:   session.Account,
+        Venue:     market.Venue,
+        Stock:     market.Symbol,
+        Price:     price,
+        Qty:       qty,
+        Direction: direction,
+        OrderType: orderType,
+    }
+    managed := oms_new(request.Order())
+    if err := request.Validate(); err != nil {
+        err = rejected("%v", err)
+        oms_rejected(managed, err)
+        return api.Order{}, err
+    }
+
+    b.nextId += 1
+    b.Report.Orders += 1
+    order := &sim_order{order: request.Order()}
+    order.order.Ok = true
+    order.order.Id = b.nextId
+    order.order.Ts = b.ts(market)
+    order.order.Open = true
+    oms_acknowledged(managed, order.order)
+
+    offer := b.FillModel.Placed(order, market.Quotes.last)
+    if orderType == api.FillOrKill && b.Partial && offer.Qty < qty {
+        // The market cannot fill it entirely, so it is killed untouched.
+        offer = Offer{}
+    }
+    b.fill(market, order, offer)
+    if order.order.Open && orderType.Immediate() {
+        // Like the venue, what the book could not fill at once is
+        // cancelled.
+        order.order.Qty = 0
+        order.order.Open = false
+        oms_update(order.order)
+    }
+    if order.order.Open {
+        b.working = append(b.working, order)
+    }
+    return order.order, nil
+}
+
+func (g sim_gateway) Cancel(order api.Order) error {
+    b := g.b
+    for i, working := range b.working {
+        if working.order.Venue == order.Venue && working.order.Id == order.Id {
+            b.Report.Cancels += 1
+            oms_cancelling(order.Venue, order.Id)
+            working.order.Open = false
+            oms_update(working.order)
+            b.working = append(b.working[:i], b.working[i+1:]...)
+            return nil
+        }
+    }
+    if _, ok := session.Order(order.Venue, order.Id); ok {
+        // Like the venue, cancelling a closed order is not an error.
+        return nil
+    }
+    return &api.Error{Method: "DELETE", Path: "backtest", StatusCode: http.StatusNotFound,
+        Message: fmt.Sprintf("no order %d on %s", order.Id, order.Venue)}
+}
+
+func (g sim_gateway) Amend(market *Market, order api.Order, qty int, price int) (api.Order, error) {
+    return amend_order(g, market, order, qty, price)
+}
@@ -0,0 +1,657 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+    "github.com/vincenzoauteri/stockfighter/mock"
+    "github.com/vincenzoauteri/stockfighter/recording"
+)
+
+type command struct {
+    name  string
+    usage string
+    run   func(args []string) error
+}
+
+var commands []command
+
+func init() {
+    commands = []command{
+        {"run", "trade with a strategy until stopped", cmd_run},
+        {"quote", "print the quote of each symbol", cmd_quote},
+        {"book", "print the order book of each symbol", cmd_book},
+        {"orders", "list the account's orders", cmd_orders},
+        {"cancel-all", "cancel every open order of the account", cmd_cancel_all},
+        {"kill", "stop every bot of the account and cancel its open orders", cmd_kill},
+        {"position", "print the positions rebuilt from the account's orders", cmd_position},
+        {"heartbeat", "check that the API and the venues are up", cmd_heartbeat},
+        {"backtest", "replay recordings through a strategy against simulated fills", cmd_backtest},
+        {"start", "start a level and print its account, venues and symbols", cmd_start},
+        {"restart", "restart the level instance", cmd_restart},
+        {"resume", "resume the stopped level instance", cmd_resume},
+        {"stop", "stop the level instance", cmd_stop},
+        {"status", "print the state of the level instance", cmd_status},
+    }
+}
+
+func usage() {
+    fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+    for _, c := range commands {
+        fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.usage)
+    }
+    fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
+func run_command(args []string) error {
+    if len(args) == 0 {
+        usage()
+        os.Exit(2)
+    }
+    for _, c := range commands {
+        if c.name == args[0] {
+            return c.run(args[1:])
+        }
+    }
+    usage()
+    return fmt.Errorf("unknown command %q", args[0])
+}
+
+func env(name string, fallback string) string {
+    if value := os.Getenv(name); value != "" {
+        return value
+    }
+    return fallback
+}
+
+func env_int(name string, fallback int) int {
+    if value, err := strconv.Atoi(os.Getenv(name)); err == nil {
+        return value
+    }
+    return fallback
+}
+
+// options are the flags shared by every command. Each defaults to an
+// environment variable so a level can be set up once per shell.
+type options struct {
+    account    string
+    venue      string
+    symbols    string
+    venueWide  bool
+    keyFile    string
+    baseUrl    string
+    gmUrl      string
+    level      string
+    instance   int
+    mock       bool
+    mark       string
+    staleQuote int
+    rateLimits string
+
+    server *mock.Server
+}
+
+func common_flags(fs *flag.FlagSet) *options {
+    o := &options{}
+    fs.StringVar(&o.account, "account", env("SF_ACCOUNT", ""), "trading account (SF_ACCOUNT)")
+    fs.StringVar(&o.venue, "venue", env("SF_VENUE", ""), "venue of symbols given without one (SF_VENUE)")
+    fs.StringVar(&o.symbols, "symbols", env("SF_SYMBOLS", ""), "comma separated SYMBOL or VENUE:SYMBOL list, the first one being the primary (SF_SYMBOLS)")
+    fs.BoolVar(&o.venueWide, "venue-wide", os.Getenv("SF_VENUE_WIDE") != "", "trade every symbol of the venues (SF_VENUE_WIDE)")
+    fs.StringVar(&o.keyFile, "keyfile", env("SF_KEYFILE", "./keyfile.dat"), "file holding the API key (SF_KEYFILE)")
+    fs.StringVar(&o.baseUrl, "base-url", env("SF_BASE_URL", api.DefaultBaseUrl), "API root (SF_BASE_URL)")
+    fs.StringVar(&o.gmUrl, "gm-url", env("SF_GM_URL", api.DefaultGmUrl), "game master API root (SF_GM_URL)")
+    fs.StringVar(&o.level, "level", env("SF_LEVEL", ""), "start this level and trade it, e.g. first_steps (SF_LEVEL)")
+    fs.IntVar(&o.instance, "instance", env_int("SF_INSTANCE", 0), "level instance id (SF_INSTANCE)")
+    fs.BoolVar(&o.mock, "mock", false, "trade against an in-process mock exchange")
+    fs.StringVar(&o.mark, "mark", env("SF_MARK", MARK_LAST), "value positions at the last trade, the mid or conservatively at the bid or ask, one of last, mid or conservative (SF_MARK)")
+    fs.IntVar(&o.staleQuote, "stale-quote", env_int("SF_STALE_QUOTE", 5000), "milliseconds after which a quote is too old to value positions, 0 to never flag it (SF_STALE_QUOTE)")
+    fs.StringVar(&o.rateLimits, "rate-limits", env("SF_RATE_LIMITS", ""), "JSON object overriding the default request rates, e.g. {\"total\":{\"rate\":10,\"burst\":10}}; a zero rate is not limited (SF_RATE_LIMITS)")
+    return o
+}
+
+// parse_rate_limits overrides the default rate limits with the fields
+// present in the JSON config. Endpoints it lists replace their default
+// budget, the others keep it.
+func parse_rate_limits(config []byte) (api.RateLimits, error) {
+    limits := api.DefaultRateLimits()
+    if len(config) > 0 {
+        if err := json.Unmarshal(config, &limits); err != nil {
+            return limits, fmt.Errorf("rate limits: %v", err)
+        }
+    }
+    return limits, nil
+}
+
+func parse_instruments(symbols string, venue string) ([]Instrument, error) {
+    var instruments []Instrument
+    for _, symbol := range strings.Split(symbols, ",") {
+        symbol = strings.TrimSpace(symbol)
+        if symbol == "" {
+            continue
+        }
+        instrument := Instrument{Venue: venue, Symbol: symbol}
+        if i := strings.Index(symbol, ":"); i >= 0 {
+            instrument = Instrument{Venue: symbol[:i], Symbol: symbol[i+1:]}
+        }
+        if instrument.Venue == "" {
+            return nil, fmt.Errorf("no venue for %s: pass -venue or use VENUE:SYMBOL", symbol)
+        }
+        instruments = append(instruments, instrument)
+    }
+    return instruments, nil
+}
+
+// connect creates the venue and game master clients. The returned function
+// releases what connect started.
+func (o *options) connect() (func(), error) {
+    limits, err := parse_rate_limits([]byte(o.rateLimits))
+    if err != nil {
+        return nil, err
+    }
+    if o.mock {
+        o.server = mock.NewServer(mock.NewExchange())
+        globals.client = o.server.Client("mock")
+        globals.client.Limiter = api.NewLimiter(limits)
+        globals.gm = o.server.GameMaster("mock")
+        return o.server.Close, nil
+    }
+
+    content, err := ioutil.ReadFile(o.keyFile)
+    if err != nil {
+        return nil, err
+    }
+    apiKey := strings.TrimSpace(string(content))
+    globals.client = api.NewClient(apiKey)
+    globals.client.BaseUrl = o.baseUrl
+    globals.client.Limiter = api.NewLimiter(limits)
+    globals.gm = api.NewGameMaster(apiKey)
+    globals.gm.BaseUrl = o.gmUrl
+    return func() {}, nil
+}
+
+// setup connects and configures the session from the parsed options. With
+// -level, the account and the instruments come from the game master.
+func (o *options) setup(needAccount bool) (func(), error) {
+    instruments, err := parse_instruments(o.symbols, o.venue)
+    if err != nil {
+        return nil, err
+    }
+    if err := check_mark(o.mark); err != nil {
+        return nil, err
+    }
+    teardown, err := o.connect()
+    if err != nil {
+        return nil, err
+    }
+
+    session.Account = o.account
+    session.Instruments = instruments
+    session.VenueWide = o.venueWide
+    session.InstanceId = o.instance
+    session.Mark = o.mark
+    session.StaleQuote = time.Duration(o.staleQuote) * time.Millisecond
+
+    if o.level != "" {
+        level, err := globals.gm.StartLevel(o.level)
+        if err != nil {
+            teardown()
+            return nil, fmt.Errorf("start level %s: %v", o.level, err)
+        }
+        apply_level(level)
+        print_level(level)
+    } else if o.mock {
+        for _, instrument := range instruments {
+            o.server.AddVenue(instrument.Venue, instrument.Symbol)
+        }
+    }
+
+    if len(session.Instruments) == 0 {
+        teardown()
+        return nil, fmt.Errorf("no symbols given (-symbols or SF_SYMBOLS)")
+    }
+    if needAccount && session.Account == "" {
+        teardown()
+        return nil, fmt.Errorf("no account given (-account or SF_ACCOUNT)")
+    }
+    return teardown, nil
+}
+
+func parse(name string, args []string) (*flag.FlagSet, *options) {
+    fs := flag.NewFlagSet(name, flag.ExitOnError)
+    return fs, common_flags(fs)
+}
+
+func cmd_run(args []string) error {
+    fs, o := parse("run", args)
+    strategyName := fs.String("strategy", env("SF_STRATEGY", "level4"), fmt.Sprintf("strategy to run, one of %v (SF_STRATEGY)", strategy_names()))
+    strategyConfig := fs.String("config", env("SF_STRATEGY_CONFIG", ""), "JSON object overriding the strategy's default configuration (SF_STRATEGY_CONFIG)")
+    interval := fs.Int("interval", env_int("SF_INTERVAL", 1000), "milliseconds between two polls of the venue (SF_INTERVAL)")
+    profile := fs.Bool("profile", os.Getenv("SF_PROFILE") != "", "print timing of the quote statistics and request queueing (SF_PROFILE)")
+    recordDir := fs.String("record", env("SF_RECORD_DIR", ""), "record the market data of the session to a file in this directory (SF_RECORD_DIR)")
+    riskConfig := fs.String("risk", env("SF_RISK", ""), "JSON object overriding the default risk limits (SF_RISK)")
+    flatten := fs.Bool("flatten", os.Getenv("SF_FLATTEN") != "", "sell back the inventory with market orders when the bot stops (SF_FLATTEN)")
+    fs.Parse(args)
+
+    strategy, err := new_strategy(*strategyName, []byte(*strategyConfig))
+    if err != nil {
+        return err
+    }
+    risk, err := parse_risk_limits([]byte(*riskConfig))
+    if err != nil {
+        return err
+    }
+    teardown, err := o.setup(true)
+    if err != nil {
+        return err
+    }
+    defer teardown()
+    if err := open_store(session.Account); err != nil {
+        return err
+    }
+    defer close_store()
+    if engaged, reason, err := kill_switch(); err != nil {
+        return err
+    } else if engaged {
+        return fmt.Errorf("kill switch engaged (%s), release it with kill -release", reason)
+    }
+
+    engine := Engine{
+        Strategy: strategy,
+        Name:     *strategyName,
+        Interval: time.Duration(*interval) * time.Millisecond,
+        Profile:  *profile,
+        Risk:     risk,
+    }
+    if *recordDir != "" {
+        recorder, err := recording.Create(*recordDir, session.Account, time.Now())
+        if err != nil {
+            return err
+        }
+        defer recorder.Close()
+        log.Printf("Recording market data to %s", recorder.Path)
+        engine.Recorder = recorder
+    }
+    err = engine.Run()
+    // Never leave orders resting on the venue once the bot stops trading,
+    // unless the level is over and the venue with it.
+    if !engine.over {
+        if err := shutdown(time.Now(), *flatten); err != nil {
+            log.Printf("Could not shut down cleanly: %s", err)
+        }
+    }
+    return err
+}
+
+func cmd_quote(args []string) error {
+    fs, o := parse("quote", args)
+    fs.Parse(args)
+    teardown, err := o.setup(false)
+    if err != nil {
+        return err
+    }
+    defer teardown()
+
+    for _, instrument := range session.Instruments {
+        quote, err := globals.client.Quote(instrument.Venue, instrument.Symbol)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("%s bid %d x %d ask %d x %d last %d x %d at %s\n", instrument,
+            quote.Bid, quote.BidSize, quote.Ask, quote.AskSize, quote.Last, quote.LastSize, quote.LastTrade)
+    }
+    return nil
+}
+
+func cmd_book(args []string) error {
+    fs, o := parse("book", args)
+    depth := fs.Int("depth", 10, "levels to print on each side")
+    fs.Parse(args)
+    teardown, err := o.setup(false)
+    if err != nil {
+        return err
+    }
+    defer teardown()
+
+    for _, instrument := range session.Instruments {
+        book, err := globals.client.OrderBook(instrument.Venue, instrument.Symbol)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("%s at %s\n", instrument, book.Ts)
+        for i := len(book.Asks) - 1; i >= 0; i-- {
+            if i < *depth {
+                fmt.Printf("    ask %8d x %d\n", book.Asks[i].Price, book.Asks[i].Qty)
+            }
+        }
+        for i, bid := range book.Bids {
+            if i < *depth {
+                fmt.Printf("    bid %8d x %d\n", bid.Price, bid.Qty)
+            }
+        }
+    }
+    return nil
+}
+
+func cmd_orders(args []string) error {
+    fs, o := parse("orders", args)
+    open := fs.Bool("open", false, "only list open orders")
+    fs.Parse(args)
+    teardown, err := o.setup(true)
+    if err != nil {
+        return err
+    }
+    defer teardown()
+
+    for _, subscription := range subscriptions() {
+        orders, err := globals.client.AllOrders(session.Account, subscription.Venue, subscription.Symbol)
+        if err != nil {
+            return err
+        }
+        for _, order := range orders {
+            if *open && !order.Open {
+                continue
+            }
+            fmt.Printf("%s:%s %d %s %s %d/%d @ %d %s %s\n", order.Venue, order.Symbol, order.Id,
+                order.Direction, order.OrderType, order.TotalFilled, order.OriginalQty, order.Price,
+                venue_state(ORDER_NEW, order), order.Ts)
+        }
+    }
+    return nil
+}
+
+func cmd_cancel_all(args []string) error {
+    fs, o := parse("cancel-all", args)
+    fs.Parse(args)
+    teardown, err := o.setup(true)
+    if err != nil {
+        return err
+    }
+    defer teardown()
+
+    if err := init_session(); err != nil {
+        return err
+    }
+    if err := sync_orders(); err != nil {
+        return err
+    }
+    return cancel_all_orders()
+}
+
+// cmd_kill engages the kill switch, which the bots of the account find on
+// their next poll, then cancels the open orders itself in case a bot is
+// hung or gone.
+func cmd_kill(args []string) error {
+    fs, o := parse("kill", args)
+    flatten := fs.Bool("flatten", false, "also sell back the inventory with market orders")
+    reason := fs.String("reason", "kill command", "why trading stops, logged by the bots")
+    release := fs.Bool("release", false, "release the kill switch so bots may trade again, and do nothing else")
+    fs.Parse(args)
+    teardown, err := o.setup(true)
+    if err != nil {
+        return err
+    }
+    defer teardown()
+    if err := open_store(session.Account); err != nil {
+        return err
+    }
+    defer close_store()
+
+    if *release {
+        if err := release_kill_switch(time.Now()); err != nil {
+            return err
+        }
+        log.Printf("Kill switch of %s released", session.Account)
+        return nil
+    }
+    if err := engage_kill_switch(time.Now(), *reason); err != nil {
+        return err
+    }
+    log.Printf("Kill switch of %s engaged: %s", session.Account, *reason)
+
+    if err := init_session(); err != nil {
+        return err
+    }
+    if err := sync_orders(); err != nil {
+        return err
+    }
+    return shutdown(time.Now(), *flatten)
+}
+
+func cmd_position(args []string) error {
+    fs, o := parse("position", args)
+    saved := fs.Bool("saved", false, "print the positions last checkpointed to the database instead")
+    fs.Parse(args)
+    teardown, err := o.setup(true)
+    if err != nil {
+        return err
+    }
+    defer teardown()
+
+    if err := init_session(); err != nil {
+        return err
+    }
+    if *saved {
+        if err := open_store(session.Account); err != nil {
+            return err
+        }
+        defer close_store()
+        if err := restore_positions(); err != nil {
+            return err
+        }
+    } else if err := sync_orders(); err != nil {
+        return err
+    }
+    show_position()
+    return nil
+}
+
+func cmd_heartbeat(args []string) error {
+    fs, o := parse("heartbeat", args)
+    fs.Parse(args)
+    teardown, err := o.setup(false)
+    if err != nil {
+        return err
+    }
+    defer teardown()
+
+    if err := globals.client.Heartbeat(); err != nil {
+        return err
+    }
+    fmt.Printf("API up\n")
+    for _, venue := range session.Venues() {
+        if err := globals.client.CheckVenue(venue); err != nil {
+            return err
+        }
+        fmt.Printf("%s up\n", venue)
+    }
+    return nil
+}
+
+func cmd_start(args []string) error {
+    fs, o := parse("start", args)
+    fs.Parse(args)
+    if o.level == "" && fs.NArg() > 0 {
+        o.level = fs.Arg(0)
+    }
+    if o.level == "" {
+        return fmt.Errorf("no level given (start <level>, -level or SF_LEVEL)")
+    }
+    teardown, err := o.connect()
+    if err != nil {
+        return err
+    }
+    defer teardown()
+
+    level, err := globals.gm.StartLevel(o.level)
+    if err != nil {
+        return err
+    }
+    for _, text := range level.Instructions {
+        fmt.Printf("%s\n\n", text)
+    }
+    print_level(level)
+    return nil
+}
+
+// instance_command connects and runs f on the instance given by -instance.
+func instance_command(name string, args []string, f func(id int) error) error {
+    fs, o := parse(name, args)
+    fs.Parse(args)
+    if o.instance == 0 {
+        return fmt.Errorf("no instance given (-instance or SF_INSTANCE)")
+    }
+    teardown, err := o.connect()
+    if err != nil {
+        return err
+    }
+    defer teardown()
+    return f(o.instance)
+}
+
+func cmd_restart(args []string) error {
+    return instance_command("restart", args, func(id int) error {
+        level, err := restart_level(id)
+        if err != nil {
+            return err
+        }
+        print_level(level)
+        return nil
+    })
+}
+
+func cmd_resume(args []string) error {
+    return instance_command("resume", args, func(id int) error {
+        level, err := globals.gm.Resume(id)
+        if err != nil {
+            return err
+        }
+        print_level(level)
+        return nil
+    })
+}
+
+func cmd_stop(args []string) error {
+    return instance_command("stop", args, func(id int) error {
+        if err := globals.gm.Stop(id); err != nil {
+            return err
+        }
+        fmt.Printf("Instance %d stopped\n", id)
+        return nil
+    })
+}
+
+func cmd_status(args []string) error {
+    return instance_command("status", args, func(id int) error {
+        instance, err := globals.gm.Instance(id)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("Instance %d %s, trading day %d of %d, done:%t\n", instance.Id, instance.State,
+            instance.Details.TradingDay, instance.Details.EndOfTheWorldDay, instance.Done)
+        print_flash(instance.Flash)
+        return nil
+    })
+}
+
+func cmd_backtest(args []string) error {
+    fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintf(os.Stderr, "usage: %s backtest [flags] recording.jsonl...\n", os.Args[0])
+        fs.PrintDefaults()
+    }
+    venue := fs.String("venue", env("SF_VENUE", ""), "venue of symbols given without one (SF_VENUE)")
+    symbols := fs.String("symbols", "", "comma separated SYMBOL or VENUE:SYMBOL list to trade, by default every one quoted in the first recording")
+    strategyName := fs.String("strategy", env("SF_STRATEGY", "level4"), fmt.Sprintf("strategy to run, one of %v (SF_STRATEGY)", strategy_names()))
+    strategyConfig := fs.String("config", env("SF_STRATEGY_CONFIG", ""), "JSON object overriding the strategy's default configuration (SF_STRATEGY_CONFIG)")
+    interval := fs.Int("interval", env_int("SF_INTERVAL", 1000), "milliseconds of recorded time between two timer events (SF_INTERVAL)")
+    model := fs.String("fill", "touch", "fill model, touch or queue")
+    partial := fs.Bool("partial", true, "fill orders only up to the shares on offer")
+    inventory := fs.String("inventory", "", "write the inventory path to this CSV file")
+    nav := fs.String("nav", "", "write the NAV series, sampled at every timer event, to this CSV file")
+    mark := fs.String("mark", env("SF_MARK", MARK_LAST), "value positions at the last trade, the mid or conservatively at the bid or ask, one of last, mid or conservative (SF_MARK)")
+    staleQuote := fs.Int("stale-quote", env_int("SF_STALE_QUOTE", 5000), "milliseconds of recorded time after which a quote is too old to value positions (SF_STALE_QUOTE)")
+    quiet := fs.Bool("quiet", false, "hide what the strategy prints, only print the report")
+    riskConfig := fs.String("risk", env("SF_RISK", ""), "JSON object overriding the default risk limits (SF_RISK)")
+    fs.Parse(args)
+
+    if fs.NArg() == 0 {
+        fs.Usage()
+        return fmt.Errorf("no recording given")
+    }
+    strategy, err := new_strategy(*strategyName, []byte(*strategyConfig))
+    if err != nil {
+        return err
+    }
+    fillModel, err := new_fill_model(*model)
+    if err != nil {
+        return err
+    }
+    risk, err := parse_risk_limits([]byte(*riskConfig))
+    if err != nil {
+        return err
+    }
+    if err := check_mark(*mark); err != nil {
+        return err
+    }
+    instruments, err := parse_instruments(*symbols, *venue)
+    if err != nil {
+        return err
+    }
+    if len(instruments) == 0 {
+        if instruments, err = recorded_instruments(fs.Arg(0)); err != nil {
+            return err
+        }
+        if len(instruments) == 0 {
+            return fmt.Errorf("no quotes in %s", fs.Arg(0))
+        }
+    }
+    session = Session{
+        Account:     "BACKTEST",
+        Instruments: instruments,
+        Mark:        *mark,
+        StaleQuote:  time.Duration(*staleQuote) * time.Millisecond,
+    }
+    if *quiet {
+        session.Out = ioutil.Discard
+    }
+
+    backtest := Backtest{
+        Strategy:  strategy,
+        FillModel: fillModel,
+        Partial:   *partial,
+        Interval:  time.Duration(*interval) * time.Millisecond,
+        Risk:      risk,
+    }
+    if err := backtest.Run(fs.Args()); err != nil {
+        return err
+    }
+
+    backtest.Report.Print(os.Stdout)
+    if *inventory != "" {
+        file, err := os.Create(*inventory)
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        if err := backtest.Report.WriteInventory(file); err != nil {
+            return err
+        }
+    }
+    if *nav != "" {
+        file, err := os.Create(*nav)
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        return backtest.Report.WriteNav(file)
+    }
+    return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+    "github.com/vincenzoauteri/stockfighter/recording"
+)
+
+// Handler reacts to market events, called from the run loop as soon as
+// each one arrives.
+type Handler interface {
+    OnQuote(market *Market, quote api.Quote) error
+    OnExecution(market *Market, execution api.Executions) error
+    OnOrderBook(market *Market, book api.OrderBook) error
+    OnTimer(now time.Time) error
+}
+
+// Consecutive failed events tolerated before the bot gives up.
+const MAX_FAILED_TICKS = 5
+
+// Engine feeds websocket messages to its Strategy as they arrive. A timer
+// polls the venue as a fallback: it reconciles orders and positions over
+// REST, fetches the order books for a BookPoller, then fires OnTimer. When
+// the session trades a level instance, the timer also checks whether the
+// level is over, which stops the engine. So does an interrupt, or the
+// account's kill switch, checked on every poll.
+type Engine struct {
+    Strategy Strategy
+    // Name under which the state of a Stateful strategy is saved.
+    Name     string
+    Interval time.Duration
+    Profile  bool
+    // Limits every order of the strategy is checked against.
+    Risk RiskLimits
+    // When set, every quote, execution and order book is recorded, and the
+    // books are fetched on every poll whatever the strategy.
+    Recorder *recording.Writer
+
+    ticks    int
+    failures int
+    // Set once the game master reports the level instance as over.
+    over bool
+    // Set once the kill switch is found engaged.
+    killed bool
+    // Gateway of the strategy's orders.
+    risk *risk_gateway
+    // State of the strategy last saved.
+    saved []byte
+}
+
+// Run owns the session: the feed goroutines only decode messages and hand
+// them over here, so quotes, orders and positions are never touched from
+// two goroutines at once.
+func (e *Engine) Run() error {
+    if err := init_session(); err != nil {
+        return err
+    }
+    if err := recover_session(time.Now()); err != nil {
+        return err
+    }
+    e.risk = new_risk_gateway(venue_gateway{}, e.Risk, time.Now)
+    if err := e.Strategy.Init(&Context{Session: &session, Orders: e.risk}); err != nil {
+        return err
+    }
+    if err := restore_strategy(e.Name, e.Strategy); err != nil {
+        return fmt.Errorf("restore %s state: %v", e.Name, err)
+    }
+    defer func() {
+        if err := e.save(time.Now()); err != nil {
+            log.Printf("Could not save %s state: %s", e.Name, err)
+        }
+    }()
+
+    if err := init_web_sockets(); err != nil {
+        return err
+    }
+    defer close_web_sockets()
+
+    stop := make(chan struct{})
+    defer close(stop)
+    quotes := run_feeds(session.quoteFeeds, stop)
+    fills := run_feeds(session.executionsFeeds, stop)
+
+    ticker := time.NewTicker(e.Interval)
+    defer ticker.Stop()
+
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+    defer signal.Stop(signals)
+
+    for {
+        var err error
+        select {
+        case message, ok := <-quotes:
+            if !ok {
+                return fmt.Errorf("tickertape feeds stopped")
+            }
+            err = e.handle(message)
+        case message, ok := <-fills:
+            if !ok {
+                return fmt.Errorf("executions feeds stopped")
+            }
+            err = e.handle(message)
+        case now := <-ticker.C:
+            err = e.poll(now)
+        case sig := <-signals:
+            log.Printf("Received %s, stopping", sig)
+            return nil
+        }
+
+        if err == nil {
+            // Orders the strategy just placed are found again if the bot
+            // dies before the next event.
+            err = e.save(time.Now())
+        }
+        if e.over || e.killed {
+            return nil
+        }
+        if err == nil {
+            e.failures = 0
+            continue
+        }
+        e.failures += 1
+        log.Printf("Event failed (%d in a row): %s", e.failures, err)
+        if e.failures >= MAX_FAILED_TICKS {
+            return fmt.Errorf("giving up after %d failed events: %v", e.failures, err)
+        }
+    }
+}
+
+// save saves the state of the strategy if it changed since last saved.
+func (e *Engine) save(now time.Time) error {
+    saved, err := save_strategy(now, e.Name, e.Strategy, e.saved)
+    e.saved = saved
+    return err
+}
+
+// trading tells whether the handler may act: never while a feed is down,
+// since quotes or fills could be missing.
+func (e *Engine) trading() bool {
+    return feeds_up()
+}
+
+func (e *Engine) record(message interface{}) error {
+    if e.Recorder == nil {
+        return nil
+    }
+    return e.Recorder.Write(time.Now(), message)
+}
+
+func (e *Engine) handle(message interface{}) error {
+    switch message.(type) {
+    case api.StockQuoteWs, api.Executions:
+        if err := e.record(message); err != nil {
+            return err
+        }
+    }
+    switch message := message.(type) {
+    case api.StockQuoteWs:
+        market := update_quotes_ws(message, time.Now())
+        if market != nil && e.trading() {
+            return e.Strategy.OnQuote(market, message.Quote)
+        }
+    case api.Executions:
+        market, err := update_executions_ws(message)
+        if err != nil {
+            return err
+        }
+        if market != nil && e.trading() {
+            return e.Strategy.OnExecution(market, message)
+        }
+    case api.Gap:
+        handle_gap(message)
+    }
+    return nil
+}
+
+func (e *Engine) poll(now time.Time) error {
+    if e.Recorder != nil {
+        defer e.Recorder.Flush()
+    }
+    e.ticks += 1
+    session.printf("Tick %d \n", e.ticks)
+    if e.Profile {
+        session.printf("Execution of function %s has taken %s and it has been executed %d times\n",
+            "update_quote_history",
+            profiling.ExecutionTime.String(),
+            profiling.Executions)
+        show_rate_limits()
+    }
+
+    engaged, reason, err := kill_switch()
+    if err != nil {
+        return err
+    }
+    if engaged {
+        log.Printf("Kill switch engaged: %s", reason)
+        e.killed = true
+        return nil
+    }
+
+    if session.InstanceId != 0 {
+        over, err := check_instance()
+        if err != nil {
+            return err
+        }
+        if over {
+            session.printf("Level instance %d is over\n", session.InstanceId)
+            e.over = true
+            return nil
+        }
+    }
+
+    if err := sync_orders(); err != nil {
+        return err
+    }
+    value_positions(now)
+    show_position()
+    if err := record_positions(now); err != nil {
+        return err
+    }
+    if err := e.risk.start_day(now); err != nil {
+        return err
+    }
+
+    if !e.trading() {
+        session.printf("Feeds down, not trading\n")
+        return nil
+    }
+
+    poller, ok := e.Strategy.(BookPoller)
+    pollBooks := ok && poller.PollBooks()
+    if pollBooks || e.Recorder != nil {
+        for _, instrument := range session.Instruments {
+            market := session.Markets[instrument]
+            if err := update_order_book(market); err != nil {
+                return err
+            }
+            if err := e.record(market.Book.last); err != nil {
+                return err
+            }
+            if !pollBooks {
+                continue
+            }
+            if err := e.Strategy.OnOrderBook(market, market.Book.last); err != nil {
+                return err
+            }
+        }
+    }
+    return e.Strategy.OnTimer(now)
+}
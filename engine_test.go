@@ -0,0 +1,96 @@
+package main
+
+import (
+    "database/sql"
+    "io/ioutil"
+    "testing"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+    "github.com/vincenzoauteri/stockfighter/mock"
+)
+
+// churn has another trader quote and trade on exchange until stop is
+// closed, so quotes and executions keep arriving on the feeds.
+func churn(exchange *mock.Exchange, stop chan struct{}) {
+    for i := 0; ; i++ {
+        select {
+        case <-stop:
+            return
+        case <-time.After(time.Millisecond):
+        }
+        price := 4900 + (i%20)*10
+        for _, order := range []struct {
+            direction api.Direction
+            price     int
+            qty       int
+        }{{api.Buy, price - 20, 50}, {api.Sell, price + 20, 50}, {api.Buy, price + 20, 10}} {
+            exchange.PlaceOrder(api.OrderRequest{Account: "OTHER", Venue: "TESTEX", Stock: "FOO",
+                Price: order.price, Qty: order.qty, Direction: order.direction, OrderType: api.Limit})
+        }
+    }
+}
+
+// The engine runs a strategy against the mock while its feeds deliver
+// quotes and executions and its timer fires, until the kill switch is
+// engaged from another connection to the database, as from another
+// process. Run with -race.
+func TestEngineUnderLoad(t *testing.T) {
+    in_store(t, "ME")
+    exchange := mock.NewExchange()
+    exchange.AddVenue("TESTEX", "FOO")
+    server := mock.NewServer(exchange)
+    defer server.Close()
+    globals.client = server.Client("ME")
+    globals.gm = server.GameMaster("ME")
+    defer func() { globals.client, globals.gm = nil, nil }()
+
+    session = Session{Account: "ME", Instruments: []Instrument{{"TESTEX", "FOO"}}, Mark: MARK_LAST,
+        Out: ioutil.Discard}
+    strategy, err := new_strategy("level4", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    engine := Engine{Strategy: strategy, Name: "level4", Interval: 10 * time.Millisecond, Risk: default_risk_limits()}
+
+    stop := make(chan struct{})
+    defer close(stop)
+    go churn(exchange, stop)
+    go func() {
+        time.Sleep(time.Second)
+        db, err := initDb("ME")
+        if err != nil {
+            t.Error(err)
+            return
+        }
+        other, err := NewRepository(db)
+        if err != nil {
+            t.Error(err)
+            return
+        }
+        defer other.Close()
+        err = other.Transaction(func(tx *sql.Tx) error {
+            return other.SetKillSwitch(tx, time.Now(), "ME", true, "test over")
+        })
+        if err != nil {
+            t.Error(err)
+        }
+    }()
+
+    done := make(chan error)
+    go func() { done <- engine.Run() }()
+    select {
+    case err := <-done:
+        if err != nil {
+            t.Fatal(err)
+        }
+    case <-time.After(10 * time.Second):
+        t.Fatal("engine still running after the kill switch was engaged")
+    }
+    if !engine.killed {
+        t.Fatal("expected the engine to stop on the kill switch")
+    }
+    if engine.ticks == 0 || len(session.Orders) == 0 {
+        t.Fatalf("expected the strategy to trade, got %d ticks and %d orders", engine.ticks, len(session.Orders))
+    }
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+    "fmt"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// sim_order is an order working on the simulated venue of a backtest.
+type sim_order struct {
+    order api.Order
+    // Shares displayed ahead of the order at its price, -1 while its price
+    // is behind the top of book and the queue is unknown.
+    queueAhead int
+}
+
+func (o *sim_order) buy() bool {
+    return o.order.Direction == api.Buy
+}
+
+// Offer is what the market offers an order: qty shares at price, taken
+// from the opposite side of the quote when crossed, else from the shares
+// that traded.
+type Offer struct {
+    Qty     int
+    Price   int
+    Crossed bool
+}
+
+// FillModel decides what the recorded market would have filled of an
+// order of ours. The backtester fills at most what is left of the order,
+// and takes what it fills out of the quote so the same shares never fill
+// twice.
+type FillModel interface {
+    // Placed is called once when order reaches the venue, with the quote
+    // at the time.
+    Placed(order *sim_order, quote api.Quote) Offer
+    // Fill is called for each new quote while order is working, traded
+    // being the shares that traded since the previous quote and are not
+    // taken yet.
+    Fill(order *sim_order, quote api.Quote, traded int) Offer
+}
+
+var fill_models = map[string]func() FillModel{
+    "touch": func() FillModel { return touch_model{} },
+    "queue": func() FillModel { return queue_model{} },
+}
+
+func new_fill_model(name string) (FillModel, error) {
+    new, ok := fill_models[name]
+    if !ok {
+        return nil, fmt.Errorf("unknown fill model %q, expected touch or queue", name)
+    }
+    return new(), nil
+}
+
+// crossing offers the opposite side of quote when order trades against it
+// at once.
+func crossing(order *sim_order, quote api.Quote) Offer {
+    market := order.order.OrderType == api.Market
+    if order.buy() {
+        if quote.Ask > 0 && (market || order.order.Price >= quote.Ask) {
+            return Offer{quote.AskSize, quote.Ask, true}
+        }
+    } else if quote.Bid > 0 && (market || order.order.Price <= quote.Bid) {
+        return Offer{quote.BidSize, quote.Bid, true}
+    }
+    return Offer{}
+}
+
+// traded_through tells whether the last trade of quote happened at a
+// price better than order's, for the other side of the trade.
+func traded_through(order *sim_order, quote api.Quote) bool {
+    if order.buy() {
+        return quote.Last < order.order.Price
+    }
+    return quote.Last > order.order.Price
+}
+
+// touch_model fills an order as soon as the market reaches its price:
+// when the opposite side of the quote crosses it, or a trade prints at or
+// through it.
+type touch_model struct{}
+
+func (touch_model) Placed(order *sim_order, quote api.Quote) Offer {
+    return crossing(order, quote)
+}
+
+func (touch_model) Fill(order *sim_order, quote api.Quote, traded int) Offer {
+    if offer := crossing(order, quote); offer.Qty > 0 {
+        return offer
+    }
+    if traded > 0 && (quote.Last == order.order.Price || traded_through(order, quote)) {
+        return Offer{Qty: traded, Price: order.order.Price}
+    }
+    return Offer{}
+}
+
+// queue_model makes an order wait behind the shares displayed at its price
+// when it arrived. Trades at its price eat the queue first; a trade
+// through its price, or the opposite side crossing it, fills it at once.
+type queue_model struct{}
+
+// join sets the queue once the order's price is the top of its side.
+func (queue_model) join(order *sim_order, quote api.Quote) {
+    best, size := quote.Bid, quote.BidSize
+    better := order.order.Price > best
+    if !order.buy() {
+        best, size = quote.Ask, quote.AskSize
+        better = best == 0 || order.order.Price < best
+    }
+    switch {
+    case order.order.Price == best:
+        order.queueAhead = size
+    case better:
+        order.queueAhead = 0
+    }
+}
+
+func (m queue_model) Placed(order *sim_order, quote api.Quote) Offer {
+    order.queueAhead = -1
+    if offer := crossing(order, quote); offer.Qty > 0 {
+        return offer
+    }
+    m.join(order, quote)
+    return Offer{}
+}
+
+func (m queue_model) Fill(order *sim_order, quote api.Quote, traded int) Offer {
+    if offer := crossing(order, quote); offer.Qty > 0 {
+        return offer
+    }
+    if traded > 0 && traded_through(order, quote) {
+        order.queueAhead = 0
+        return Offer{Qty: traded, Price: order.order.Price}
+    }
+    if order.queueAhead < 0 {
+        m.join(order, quote)
+        return Offer{}
+    }
+    if traded == 0 || quote.Last != order.order.Price {
+        return Offer{}
+    }
+    eaten := traded
+    if eaten > order.queueAhead {
+        eaten = order.queueAhead
+    }
+    order.queueAhead -= eaten
+    return Offer{Qty: traded - eaten, Price: order.order.Price}
+}
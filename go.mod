@@ -0,0 +1,8 @@
+module github.com/vincenzoauteri/stockfighter
+
+go 1.24.0
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.32
+	golang.org/x/net v0.44.0
+)
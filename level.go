@@ -0,0 +1,93 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "strings"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// apply_level points the session at a level instance started by the game
+// master: its account, and every ticker on every venue of the level unless
+// instruments were given explicitly.
+func apply_level(level api.Level) {
+    session.Account = level.Account
+    session.InstanceId = level.InstanceId
+    if len(session.Instruments) > 0 {
+        return
+    }
+    for _, venue := range level.Venues {
+        for _, ticker := range level.Tickers {
+            session.Instruments = append(session.Instruments, Instrument{Venue: venue, Symbol: ticker})
+        }
+    }
+}
+
+// restart_level restarts a level instance. Its trading days count from the
+// first again, so the NAV its days started at so far are forgotten: they
+// would be taken for those of the days to come.
+func restart_level(id int) (api.Level, error) {
+    level, err := globals.gm.Restart(id)
+    if err != nil {
+        return level, err
+    }
+    if globals.store == nil {
+        if err := open_store(level.Account); err != nil {
+            return level, err
+        }
+        defer close_store()
+    }
+    err = in_transaction(func(tx *sql.Tx) error {
+        return globals.store.ForgetDayStarts(tx, level.Account, id)
+    })
+    return level, err
+}
+
+// print_level shows the instance and the environment that lets the other
+// commands trade it.
+func print_level(level api.Level) {
+    var symbols []string
+    for _, venue := range level.Venues {
+        for _, ticker := range level.Tickers {
+            symbols = append(symbols, Instrument{Venue: venue, Symbol: ticker}.String())
+        }
+    }
+    fmt.Printf("Instance %d: account %s trading %s, %d seconds per trading day\n",
+        level.InstanceId, level.Account, strings.Join(symbols, ","), level.SecondsPerTradingDay)
+    fmt.Printf("export SF_ACCOUNT=%s SF_INSTANCE=%d SF_SYMBOLS=%s\n",
+        level.Account, level.InstanceId, strings.Join(symbols, ","))
+}
+
+func print_flash(flash api.Flash) {
+    for _, message := range []struct{ kind, text string }{
+        {"Info", flash.Info},
+        {"Success", flash.Success},
+        {"Warning", flash.Warning},
+        {"Danger", flash.Danger},
+    } {
+        if message.text != "" {
+            session.printf("%s: %s\n", message.kind, message.text)
+        }
+    }
+}
+
+// check_instance refreshes the status of the level instance, printing the
+// trading day when it changes and any new message from the game master.
+// It reports whether the level is over.
+func check_instance() (bool, error) {
+    instance, err := globals.gm.Instance(session.InstanceId)
+    if err != nil {
+        return false, err
+    }
+    previous := session.Instance
+    session.Instance = instance
+
+    if instance.Details.TradingDay != previous.Details.TradingDay {
+        session.printf("Trading day %d of %d\n", instance.Details.TradingDay, instance.Details.EndOfTheWorldDay)
+    }
+    if instance.Flash != previous.Flash {
+        print_flash(instance.Flash)
+    }
+    return instance.Done || instance.EndOfDays(), nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+)
+
+// migrations upgrade the account database one step at a time: a database
+// at version n has had the first n applied. Never change a migration once
+// released, append a new one instead.
+var migrations = []string{
+    // 1: the original schema.
+    `CREATE table position (stock string not null primary key, owned integer , balance integer) ;
+    CREATE table orders (id number not null primary key, stock string, direction string, type string, price number, qty number, filled number, open integer);`,
+
+    // 2: orders keyed by venue, fills and position snapshots. Nothing was
+    // ever written to the tables of 1, so they are dropped.
+    `DROP table position;
+    DROP table orders;
+    CREATE table orders (venue text not null, id integer not null, account text, symbol text, direction text, type text,
+        price integer, original_qty integer, qty integer, filled integer, open integer, ts text, updated_at text,
+        primary key (venue, id));
+    CREATE table fills (venue text not null, symbol text, account text, order_id integer,
+        standing_id integer not null, incoming_id integer not null, price integer, qty integer, filled_at text not null,
+        standing_complete integer, incoming_complete integer,
+        unique (venue, order_id, standing_id, incoming_id, filled_at));
+    CREATE table position_snapshots (ts text not null, venue text not null, symbol text not null,
+        owned integer, balance integer, nav integer);`,
+
+    // 3: the latest position of each instrument, restored on startup.
+    `CREATE table positions (venue text not null, symbol text not null,
+        owned integer, balance integer, nav integer, updated_at text,
+        primary key (venue, symbol));`,
+
+    // 4: state of the Stateful strategies, restored on startup.
+    `CREATE table strategy_state (account text not null, strategy text not null, state text, updated_at text,
+        primary key (account, strategy));`,
+
+    // 5: average cost and PnL of the positions.
+    `ALTER table positions ADD column avg_cost real default 0;
+    ALTER table positions ADD column realised integer default 0;
+    ALTER table positions ADD column unrealised integer default 0;
+    ALTER table position_snapshots ADD column avg_cost real default 0;
+    ALTER table position_snapshots ADD column realised integer default 0;
+    ALTER table position_snapshots ADD column unrealised integer default 0;`,
+
+    // 6: the mark each snapshot was valued at and the age of its quote.
+    `ALTER table position_snapshots ADD column mark text;
+    ALTER table position_snapshots ADD column mark_price integer;
+    ALTER table position_snapshots ADD column quote_age_ms integer;`,
+
+    // 7: the kill switch of each account.
+    `CREATE table kill_switch (account text not null primary key, engaged integer, reason text, updated_at text);`,
+
+    // 8: the NAV each trading day of an account started at.
+    `CREATE table day_start (account text not null, day integer not null, nav integer, started_at text,
+        primary key (account, day));`,
+
+    // 9: fills keyed by their place among the fills of their order, so the
+    // fills sync_orders finds are recorded too. Those come without the ids
+    // of the orders they traded between.
+    `ALTER table fills RENAME TO fills_8;
+    CREATE table fills (venue text not null, symbol text, account text, order_id integer not null, fill_index integer not null,
+        standing_id integer, incoming_id integer, price integer, qty integer, filled_at text not null,
+        standing_complete integer, incoming_complete integer,
+        primary key (venue, order_id, fill_index));
+    INSERT INTO fills SELECT venue, symbol, account, order_id,
+        row_number() OVER (PARTITION BY venue, order_id ORDER BY filled_at, rowid) - 1,
+        standing_id, incoming_id, price, qty, filled_at, standing_complete, incoming_complete FROM fills_8;
+    DROP table fills_8;`,
+
+    // 10: the day starts of each level instance apart: trading days count
+    // from the first again when an instance restarts.
+    `ALTER table day_start RENAME TO day_start_9;
+    CREATE table day_start (account text not null, instance integer not null, day integer not null, nav integer,
+        started_at text, primary key (account, instance, day));
+    INSERT INTO day_start SELECT account, 0, day, nav, started_at FROM day_start_9;
+    DROP table day_start_9;`,
+}
+
+func table_exists(db *sql.DB, table string) (bool, error) {
+    var name string
+    err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name=?;`, table).Scan(&name)
+    if err == sql.ErrNoRows {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+// schema_version reads the version of the database. Databases created
+// before versioning are recognised by their tables, and reported as not
+// versioned.
+func schema_version(db *sql.DB) (int, bool, error) {
+    versioned, err := table_exists(db, "schema_version")
+    if err != nil {
+        return 0, false, err
+    }
+    if versioned {
+        var version int
+        err := db.QueryRow(`SELECT version FROM schema_version;`).Scan(&version)
+        return version, true, err
+    }
+
+    for _, known := range []struct {
+        table   string
+        version int
+    }{{"fills", 2}, {"position", 1}} {
+        exists, err := table_exists(db, known.table)
+        if err != nil {
+            return 0, false, err
+        }
+        if exists {
+            return known.version, false, nil
+        }
+    }
+    return 0, false, nil
+}
+
+func set_schema_version(tx *sql.Tx, version int) error {
+    _, err := tx.Exec(`CREATE table IF NOT EXISTS schema_version (version integer not null);
+        DELETE FROM schema_version;`)
+    if err == nil {
+        _, err = tx.Exec(`INSERT INTO schema_version (version) VALUES (?);`, version)
+    }
+    return err
+}
+
+// migrate applies the pending migrations, each in a transaction together
+// with the version it brings the database to.
+func migrate(db *sql.DB) (int, error) {
+    version, versioned, err := schema_version(db)
+    if err != nil {
+        return 0, fmt.Errorf("read schema version: %v", err)
+    }
+    if version > len(migrations) {
+        return version, fmt.Errorf("database at schema version %d, newer than this build (%d)", version, len(migrations))
+    }
+    if !versioned && version == len(migrations) {
+        tx, err := db.Begin()
+        if err != nil {
+            return version, err
+        }
+        if err := set_schema_version(tx, version); err != nil {
+            tx.Rollback()
+            return version, err
+        }
+        return version, tx.Commit()
+    }
+
+    for version < len(migrations) {
+        tx, err := db.Begin()
+        if err != nil {
+            return version, err
+        }
+        _, err = tx.Exec(migrations[version])
+        if err == nil {
+            err = set_schema_version(tx, version+1)
+        }
+        if err != nil {
+            tx.Rollback()
+            return version, fmt.Errorf("migrate schema to version %d: %v", version+1, err)
+        }
+        if err := tx.Commit(); err != nil {
+            return version, err
+        }
+        version += 1
+    }
+    return version, nil
+}
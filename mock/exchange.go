@@ -0,0 +1,423 @@
+// Package mock is an in-process Stockfighter venue: it serves the same REST
+// and websocket API as the real exchange, backed by a price-time priority
+// matching engine, so strategies can be exercised without the network.
+package mock
+
+import (
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// Error carries the HTTP status the venue answers with alongside the
+// message that ends up in the "error" field of the response.
+type Error struct {
+    StatusCode int
+    Message    string
+}
+
+func (e *Error) Error() string {
+    return e.Message
+}
+
+func errorf(statusCode int, format string, args ...interface{}) *Error {
+    return &Error{StatusCode: statusCode, Message: fmt.Sprintf(format, args...)}
+}
+
+type book struct {
+    venue  string
+    symbol string
+    name   string
+
+    // Resting orders, best price first and oldest first within a price.
+    bids []*api.Order
+    asks []*api.Order
+
+    last      int
+    lastSize  int
+    lastTrade string
+}
+
+type Exchange struct {
+    mu          sync.Mutex
+    venues      map[string]map[string]*book
+    orders      map[int]*api.Order
+    nextId      int
+    subscribers map[*subscriber]struct{}
+
+    instances    map[int]*instance
+    nextInstance int
+
+    // Now stamps orders, fills and quotes. It defaults to time.Now and can
+    // be replaced to make a session deterministic.
+    Now func() time.Time
+}
+
+func NewExchange() *Exchange {
+    return &Exchange{
+        venues:      make(map[string]map[string]*book),
+        orders:      make(map[int]*api.Order),
+        subscribers: make(map[*subscriber]struct{}),
+        instances:   make(map[int]*instance),
+        Now:         time.Now,
+    }
+}
+
+// AddVenue lists the given symbols on venue, creating the venue if needed.
+func (e *Exchange) AddVenue(venue string, symbols ...string) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.addVenue(venue, symbols...)
+}
+
+func (e *Exchange) addVenue(venue string, symbols ...string) {
+    books, ok := e.venues[venue]
+    if !ok {
+        books = make(map[string]*book)
+        e.venues[venue] = books
+    }
+    for _, symbol := range symbols {
+        if _, ok := books[symbol]; !ok {
+            books[symbol] = &book{venue: venue, symbol: symbol, name: symbol}
+        }
+    }
+}
+
+func (e *Exchange) now() string {
+    return e.Now().UTC().Format(time.RFC3339Nano)
+}
+
+func (e *Exchange) book(venue string, symbol string) (*book, *Error) {
+    books, ok := e.venues[venue]
+    if !ok {
+        return nil, errorf(http.StatusNotFound, "No venue exists with the symbol %s", venue)
+    }
+    b, ok := books[symbol]
+    if !ok {
+        return nil, errorf(http.StatusNotFound, "symbol %s does not exist on venue %s", symbol, venue)
+    }
+    return b, nil
+}
+
+func (e *Exchange) HasVenue(venue string) bool {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    _, ok := e.venues[venue]
+    return ok
+}
+
+func (e *Exchange) Stocks(venue string) ([]api.Symbol, error) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    books, ok := e.venues[venue]
+    if !ok {
+        return nil, errorf(http.StatusNotFound, "No venue exists with the symbol %s", venue)
+    }
+    symbols := make([]api.Symbol, 0, len(books))
+    for _, b := range books {
+        symbols = append(symbols, api.Symbol{Name: b.name, Symbol: b.symbol})
+    }
+    return symbols, nil
+}
+
+func copyOrder(order *api.Order) api.Order {
+    c := *order
+    c.Fills = append([]api.Fill{}, order.Fills...)
+    return c
+}
+
+func (b *book) quote(ts string) api.Quote {
+    quote := api.Quote{
+        Symbol:    b.symbol,
+        Venue:     b.venue,
+        Last:      b.last,
+        LastSize:  b.lastSize,
+        LastTrade: b.lastTrade,
+        QuoteTime: ts,
+    }
+    for _, order := range b.bids {
+        if order.Price == b.bids[0].Price {
+            quote.Bid = order.Price
+            quote.BidSize += order.Qty
+        }
+        quote.BidDepth += order.Qty
+    }
+    for _, order := range b.asks {
+        if order.Price == b.asks[0].Price {
+            quote.Ask = order.Price
+            quote.AskSize += order.Qty
+        }
+        quote.AskDepth += order.Qty
+    }
+    return quote
+}
+
+func (e *Exchange) Quote(venue string, symbol string) (api.StockQuote, error) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    b, err := e.book(venue, symbol)
+    if err != nil {
+        return api.StockQuote{}, err
+    }
+    return api.StockQuote{Ok: true, Quote: b.quote(e.now())}, nil
+}
+
+func levels(orders []*api.Order) []api.BookEntry {
+    entries := make([]api.BookEntry, 0, len(orders))
+    for _, order := range orders {
+        entries = append(entries, api.BookEntry{
+            Price: order.Price,
+            Qty:   order.Qty,
+            IsBuy: order.Direction == api.Buy,
+        })
+    }
+    return entries
+}
+
+func (e *Exchange) OrderBook(venue string, symbol string) (api.OrderBook, error) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    b, err := e.book(venue, symbol)
+    if err != nil {
+        return api.OrderBook{}, err
+    }
+    return api.OrderBook{
+        Ok:     true,
+        Venue:  venue,
+        Symbol: symbol,
+        Bids:   levels(b.bids),
+        Asks:   levels(b.asks),
+        Ts:     e.now(),
+    }, nil
+}
+
+// PlaceOrder matches an incoming order against the book and rests whatever
+// is left of a limit order. The other types never rest: what market and
+// immediate-or-cancel orders could not fill is cancelled, and a
+// fill-or-kill order the book cannot fill entirely is cancelled untouched.
+// Either way the order comes back closed.
+func (e *Exchange) PlaceOrder(request api.OrderRequest) (api.Order, error) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    b, err := e.book(request.Venue, request.Stock)
+    if err != nil {
+        return api.Order{}, err
+    }
+    if request.Account == "" {
+        return api.Order{}, errorf(http.StatusUnauthorized, "missing account")
+    }
+    if err := request.Validate(); err != nil {
+        return api.Order{}, errorf(http.StatusBadRequest, "%v", err)
+    }
+
+    e.nextId++
+    ts := e.now()
+    order := request.Order()
+    order.Ok = true
+    order.Id = e.nextId
+    order.Ts = ts
+    order.Fills = []api.Fill{}
+    order.Open = true
+    e.orders[order.Id] = &order
+
+    traded := false
+    if request.OrderType != api.FillOrKill || fillable(b, &order) >= order.Qty {
+        traded = e.match(b, &order, ts)
+    }
+
+    if order.Qty > 0 && !request.OrderType.Immediate() {
+        b.insert(&order)
+    } else {
+        order.Qty = 0
+        order.Open = false
+    }
+
+    if traded || order.Open {
+        e.publishQuote(b, ts)
+    }
+    return copyOrder(&order), nil
+}
+
+func crosses(incoming *api.Order, standing *api.Order) bool {
+    if incoming.OrderType == api.Market {
+        return true
+    }
+    if incoming.Direction == api.Buy {
+        return standing.Price <= incoming.Price
+    }
+    return standing.Price >= incoming.Price
+}
+
+// fillable is how many shares of incoming the book would fill now.
+func fillable(b *book, incoming *api.Order) int {
+    opposite := b.asks
+    if incoming.Direction == api.Sell {
+        opposite = b.bids
+    }
+    shares := 0
+    for _, standing := range opposite {
+        if shares >= incoming.Qty || !crosses(incoming, standing) {
+            break
+        }
+        shares += standing.Qty
+    }
+    return shares
+}
+
+// match fills incoming against the opposite side of the book at the
+// standing orders' prices, in price-time priority.
+func (e *Exchange) match(b *book, incoming *api.Order, ts string) bool {
+    opposite := &b.asks
+    if incoming.Direction == api.Sell {
+        opposite = &b.bids
+    }
+
+    traded := false
+    for incoming.Qty > 0 && len(*opposite) > 0 {
+        standing := (*opposite)[0]
+        if !crosses(incoming, standing) {
+            break
+        }
+
+        qty := incoming.Qty
+        if standing.Qty < qty {
+            qty = standing.Qty
+        }
+        price := standing.Price
+
+        for _, order := range []*api.Order{standing, incoming} {
+            order.Fills = append(order.Fills, api.Fill{Price: price, Qty: qty, Ts: ts})
+            order.Qty -= qty
+            order.TotalFilled += qty
+            order.Open = order.Qty > 0
+        }
+        if !standing.Open {
+            *opposite = (*opposite)[1:]
+        }
+
+        b.last = price
+        b.lastSize = qty
+        b.lastTrade = ts
+        traded = true
+
+        for _, order := range []*api.Order{standing, incoming} {
+            e.publishExecution(api.Executions{
+                Ok:               true,
+                Account:          order.Account,
+                Venue:            b.venue,
+                Symbol:           b.symbol,
+                Order:            copyOrder(order),
+                StandingId:       standing.Id,
+                IncomingId:       incoming.Id,
+                Price:            price,
+                Filled:           qty,
+                FilledAt:         ts,
+                StandingComplete: !standing.Open,
+                IncomingComplete: incoming.Qty == 0,
+            })
+        }
+    }
+    return traded
+}
+
+func (b *book) insert(order *api.Order) {
+    side := &b.bids
+    better := func(price int) bool { return order.Price > price }
+    if order.Direction == api.Sell {
+        side = &b.asks
+        better = func(price int) bool { return order.Price < price }
+    }
+
+    i := 0
+    for i < len(*side) && !better((*side)[i].Price) {
+        i++
+    }
+    *side = append(*side, nil)
+    copy((*side)[i+1:], (*side)[i:])
+    (*side)[i] = order
+}
+
+func (b *book) remove(order *api.Order) {
+    side := &b.bids
+    if order.Direction == api.Sell {
+        side = &b.asks
+    }
+    for i, resting := range *side {
+        if resting == order {
+            *side = append((*side)[:i], (*side)[i+1:]...)
+            return
+        }
+    }
+}
+
+func (e *Exchange) order(venue string, symbol string, id int) (*api.Order, *Error) {
+    if _, err := e.book(venue, symbol); err != nil {
+        return nil, err
+    }
+    order, ok := e.orders[id]
+    if !ok || order.Venue != venue || order.Symbol != symbol {
+        return nil, errorf(http.StatusNotFound, "No order %d on %s for %s", id, venue, symbol)
+    }
+    return order, nil
+}
+
+func (e *Exchange) OrderStatus(venue string, symbol string, id int) (api.Order, error) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    order, err := e.order(venue, symbol, id)
+    if err != nil {
+        return api.Order{}, err
+    }
+    return copyOrder(order), nil
+}
+
+// CancelOrder takes an order off the book. Cancelling a closed order is
+// not an error: the venue simply returns its final state.
+func (e *Exchange) CancelOrder(venue string, symbol string, id int) (api.Order, error) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    order, err := e.order(venue, symbol, id)
+    if err != nil {
+        return api.Order{}, err
+    }
+    if order.Open {
+        b, _ := e.book(venue, symbol)
+        b.remove(order)
+        order.Qty = 0
+        order.Open = false
+        e.publishQuote(b, e.now())
+    }
+    return copyOrder(order), nil
+}
+
+// AllOrders lists the orders of account on venue, restricted to symbol
+// unless it is empty.
+func (e *Exchange) AllOrders(account string, venue string, symbol string) ([]api.Order, error) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    if _, ok := e.venues[venue]; !ok {
+        return nil, errorf(http.StatusNotFound, "No venue exists with the symbol %s", venue)
+    }
+    orders := []api.Order{}
+    for id := 1; id <= e.nextId; id++ {
+        order, ok := e.orders[id]
+        if !ok || order.Account != account || order.Venue != venue {
+            continue
+        }
+        if symbol != "" && order.Symbol != symbol {
+            continue
+        }
+        orders = append(orders, copyOrder(order))
+    }
+    return orders, nil
+}
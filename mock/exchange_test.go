@@ -0,0 +1,145 @@
+package mock
+
+import (
+    "reflect"
+    "testing"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+func newTestExchange() *Exchange {
+    e := NewExchange()
+    e.AddVenue("TESTEX", "FOO")
+    return e
+}
+
+func place(t *testing.T, e *Exchange, direction api.Direction, orderType api.OrderType, price int, qty int) api.Order {
+    t.Helper()
+    order, err := e.PlaceOrder(api.OrderRequest{Account: "ME", Venue: "TESTEX", Stock: "FOO",
+        Price: price, Qty: qty, Direction: direction, OrderType: orderType})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return order
+}
+
+func status(t *testing.T, e *Exchange, id int) api.Order {
+    t.Helper()
+    order, err := e.OrderStatus("TESTEX", "FOO", id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return order
+}
+
+// fills lists the price and quantity of each fill of order.
+func fills(order api.Order) [][2]int {
+    var fills [][2]int
+    for _, fill := range order.Fills {
+        fills = append(fills, [2]int{fill.Price, fill.Qty})
+    }
+    return fills
+}
+
+func checkBook(t *testing.T, e *Exchange, bids []api.BookEntry, asks []api.BookEntry) {
+    t.Helper()
+    book, err := e.OrderBook("TESTEX", "FOO")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(book.Bids, bids) || !reflect.DeepEqual(book.Asks, asks) {
+        t.Fatalf("expected bids %v asks %v, got bids %v asks %v", bids, asks, book.Bids, book.Asks)
+    }
+}
+
+// The best price fills first, and the oldest order first within a price.
+func TestPriceTimePriority(t *testing.T) {
+    e := newTestExchange()
+    high := place(t, e, api.Sell, api.Limit, 101, 10)
+    first := place(t, e, api.Sell, api.Limit, 100, 10)
+    second := place(t, e, api.Sell, api.Limit, 100, 10)
+
+    order := place(t, e, api.Buy, api.Limit, 101, 15)
+    if want := [][2]int{{100, 10}, {100, 5}}; !reflect.DeepEqual(fills(order), want) || order.Open {
+        t.Fatalf("expected fills %v closing the order, got %v open %t", want, fills(order), order.Open)
+    }
+    if first := status(t, e, first.Id); first.Open || first.TotalFilled != 10 {
+        t.Fatalf("expected the oldest ask at 100 filled, got %+v", first)
+    }
+    if second := status(t, e, second.Id); !second.Open || second.TotalFilled != 5 {
+        t.Fatalf("expected 5 shares of the second ask at 100 filled, got %+v", second)
+    }
+    if high := status(t, e, high.Id); high.TotalFilled != 0 {
+        t.Fatalf("expected the ask at 101 untouched, got %+v", high)
+    }
+    checkBook(t, e, []api.BookEntry{}, []api.BookEntry{{Price: 100, Qty: 5}, {Price: 101, Qty: 10}})
+}
+
+// Fills are at the standing order's price, whatever the incoming order
+// offered, and what is left of either side stays on the book.
+func TestPartialFills(t *testing.T) {
+    e := newTestExchange()
+    ask := place(t, e, api.Sell, api.Limit, 100, 50)
+
+    order := place(t, e, api.Buy, api.Limit, 105, 30)
+    if want := [][2]int{{100, 30}}; !reflect.DeepEqual(fills(order), want) || order.Open {
+        t.Fatalf("expected fills %v closing the order, got %v open %t", want, fills(order), order.Open)
+    }
+    if ask := status(t, e, ask.Id); !ask.Open || ask.Qty != 20 || ask.TotalFilled != 30 {
+        t.Fatalf("expected 20 shares of the ask left, got %+v", ask)
+    }
+
+    order = place(t, e, api.Buy, api.Limit, 105, 50)
+    if want := [][2]int{{100, 20}}; !reflect.DeepEqual(fills(order), want) || !order.Open || order.Qty != 30 {
+        t.Fatalf("expected fills %v leaving 30 shares open, got %v qty %d open %t",
+            want, fills(order), order.Qty, order.Open)
+    }
+    checkBook(t, e, []api.BookEntry{{Price: 105, Qty: 30, IsBuy: true}}, []api.BookEntry{})
+}
+
+// A fill-or-kill order the book cannot fill entirely trades nothing.
+func TestFillOrKillUnfilled(t *testing.T) {
+    e := newTestExchange()
+    place(t, e, api.Sell, api.Limit, 100, 30)
+    place(t, e, api.Sell, api.Limit, 102, 30)
+
+    order := place(t, e, api.Buy, api.FillOrKill, 101, 40)
+    if order.Open || order.TotalFilled != 0 || len(order.Fills) != 0 {
+        t.Fatalf("expected the order closed unfilled, got %+v", order)
+    }
+    checkBook(t, e, []api.BookEntry{}, []api.BookEntry{{Price: 100, Qty: 30}, {Price: 102, Qty: 30}})
+
+    order = place(t, e, api.Buy, api.FillOrKill, 102, 40)
+    if want := [][2]int{{100, 30}, {102, 10}}; !reflect.DeepEqual(fills(order), want) || order.Open {
+        t.Fatalf("expected fills %v closing the order, got %v open %t", want, fills(order), order.Open)
+    }
+}
+
+// What an immediate-or-cancel order could not fill is cancelled, not
+// rested.
+func TestImmediateOrCancelLeftover(t *testing.T) {
+    e := newTestExchange()
+    place(t, e, api.Sell, api.Limit, 100, 30)
+
+    order := place(t, e, api.Buy, api.ImmediateOrCancel, 100, 50)
+    if want := [][2]int{{100, 30}}; !reflect.DeepEqual(fills(order), want) {
+        t.Fatalf("expected fills %v, got %v", want, fills(order))
+    }
+    if order.Open || order.Qty != 0 || order.TotalFilled != 30 {
+        t.Fatalf("expected the leftover cancelled, got %+v", order)
+    }
+    if status := status(t, e, order.Id); status.Open {
+        t.Fatalf("expected the order closed on the venue, got %+v", status)
+    }
+    checkBook(t, e, []api.BookEntry{}, []api.BookEntry{})
+}
+
+// A market order finding nothing to trade against is cancelled.
+func TestMarketOrderEmptyBook(t *testing.T) {
+    e := newTestExchange()
+    order := place(t, e, api.Buy, api.Market, 0, 10)
+    if order.Open || order.Qty != 0 || order.TotalFilled != 0 || len(order.Fills) != 0 {
+        t.Fatalf("expected the order closed unfilled, got %+v", order)
+    }
+    checkBook(t, e, []api.BookEntry{}, []api.BookEntry{})
+}
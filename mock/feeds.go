@@ -0,0 +1,85 @@
+package mock
+
+import (
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// Messages queued for a websocket subscriber that stops reading are
+// dropped once this many are pending, like the real feeds do.
+const subscriberBuffer = 1024
+
+type subscriber struct {
+    feed    string
+    account string
+    venue   string
+    symbol  string
+
+    messages chan interface{}
+    closed   chan struct{}
+}
+
+func (s *subscriber) wants(venue string, symbol string) bool {
+    return s.venue == venue && (s.symbol == "" || s.symbol == symbol)
+}
+
+func (s *subscriber) send(message interface{}) {
+    select {
+    case s.messages <- message:
+    default:
+    }
+}
+
+func (e *Exchange) subscribe(feed string, account string, venue string, symbol string) *subscriber {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    s := &subscriber{
+        feed:     feed,
+        account:  account,
+        venue:    venue,
+        symbol:   symbol,
+        messages: make(chan interface{}, subscriberBuffer),
+        closed:   make(chan struct{}),
+    }
+    e.subscribers[s] = struct{}{}
+    return s
+}
+
+func (e *Exchange) unsubscribe(s *subscriber) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    if _, ok := e.subscribers[s]; ok {
+        delete(e.subscribers, s)
+        close(s.closed)
+    }
+}
+
+// CloseFeeds disconnects every websocket client, as happens when the real
+// venue drops its sockets.
+func (e *Exchange) CloseFeeds() {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    for s := range e.subscribers {
+        delete(e.subscribers, s)
+        close(s.closed)
+    }
+}
+
+func (e *Exchange) publishQuote(b *book, ts string) {
+    message := api.StockQuoteWs{Ok: true, Quote: b.quote(ts)}
+    for s := range e.subscribers {
+        if s.feed == "tickertape" && s.wants(b.venue, b.symbol) {
+            s.send(message)
+        }
+    }
+}
+
+func (e *Exchange) publishExecution(execution api.Executions) {
+    for s := range e.subscribers {
+        if s.feed == "executions" && s.account == execution.Account && s.wants(execution.Venue, execution.Symbol) {
+            s.send(execution)
+        }
+    }
+}
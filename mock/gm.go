@@ -0,0 +1,167 @@
+package mock
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// Every mock level trades a single stock on a venue of its own, with
+// trading days passing every SecondsPerTradingDay.
+const (
+    SecondsPerTradingDay = 5
+    EndOfTheWorldDay     = 380
+)
+
+type instance struct {
+    name    string
+    level   api.Level
+    state   string
+    started time.Time
+}
+
+func (e *Exchange) tradingDay(i *instance) int {
+    return int(e.Now().Sub(i.started)/(SecondsPerTradingDay*time.Second)) + 1
+}
+
+// open lists the level's stock on its venue and (re)starts the clock.
+func (e *Exchange) open(i *instance) api.Level {
+    e.addVenue(i.level.Venues[0], i.level.Tickers[0])
+    i.state = "open"
+    i.started = e.Now()
+    return i.level
+}
+
+func (e *Exchange) instance(id int) (*instance, *Error) {
+    i, ok := e.instances[id]
+    if !ok {
+        return nil, errorf(http.StatusNotFound, "No instance %d", id)
+    }
+    return i, nil
+}
+
+// StartLevel starts an instance of the named level, or returns the one
+// already running for it.
+func (e *Exchange) StartLevel(name string) (api.Level, error) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    for _, i := range e.instances {
+        if i.name == name && i.state == "open" {
+            return i.level, nil
+        }
+    }
+    e.nextInstance += 1
+    id := e.nextInstance
+    i := &instance{name: name, level: api.Level{
+        Ok:                   true,
+        Account:              fmt.Sprintf("EXB%06d", id),
+        InstanceId:           id,
+        Instructions:         map[string]string{"Instructions": "Mock instance of " + name},
+        Tickers:              []string{fmt.Sprintf("FOO%d", id)},
+        Venues:               []string{fmt.Sprintf("TEST%dEX", id)},
+        SecondsPerTradingDay: SecondsPerTradingDay,
+        Balances:             map[string]int{"USD": 0},
+    }}
+    e.instances[id] = i
+    return e.open(i), nil
+}
+
+// Restart and Resume reopen an instance. Unlike the real game master,
+// orders resting on the venue are kept.
+func (e *Exchange) Restart(id int) (api.Level, error) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    i, err := e.instance(id)
+    if err != nil {
+        return api.Level{}, err
+    }
+    return e.open(i), nil
+}
+
+func (e *Exchange) Resume(id int) (api.Level, error) {
+    return e.Restart(id)
+}
+
+func (e *Exchange) Stop(id int) error {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    i, err := e.instance(id)
+    if err != nil {
+        return err
+    }
+    i.state = "stopped"
+    return nil
+}
+
+func (e *Exchange) Instance(id int) (api.Instance, error) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    i, err := e.instance(id)
+    if err != nil {
+        return api.Instance{}, err
+    }
+    status := api.Instance{
+        Ok:    true,
+        Id:    id,
+        State: i.state,
+        Done:  i.state != "open",
+        Details: api.InstanceDetails{
+            EndOfTheWorldDay: EndOfTheWorldDay,
+            TradingDay:       e.tradingDay(i),
+        },
+    }
+    if status.Details.TradingDay >= EndOfTheWorldDay {
+        status.Done = true
+        status.Details.TradingDay = EndOfTheWorldDay
+        status.Flash.Info = "The level is over."
+    }
+    return status, nil
+}
+
+// serveGm answers the paths of https://www.stockfighter.io/gm.
+func (e *Exchange) serveGm(w http.ResponseWriter, r *http.Request, parts []string) {
+    if !authorized(r) {
+        writeError(w, errorf(http.StatusUnauthorized, "Missing API key"))
+        return
+    }
+
+    if len(parts) == 2 && parts[0] == "levels" && r.Method == "POST" {
+        level, err := e.StartLevel(parts[1])
+        writeResult(w, level, err)
+        return
+    }
+
+    if len(parts) < 2 || parts[0] != "instances" {
+        writeError(w, errorf(http.StatusNotFound, "no route for %s %s", r.Method, r.URL.Path))
+        return
+    }
+    id, err := strconv.Atoi(parts[1])
+    if err != nil {
+        writeError(w, errorf(http.StatusBadRequest, "Bad instance id %s", parts[1]))
+        return
+    }
+
+    switch {
+    case len(parts) == 2 && r.Method == "GET":
+        status, err := e.Instance(id)
+        writeResult(w, status, err)
+    case len(parts) == 3 && parts[2] == "restart" && r.Method == "POST":
+        level, err := e.Restart(id)
+        writeResult(w, level, err)
+    case len(parts) == 3 && parts[2] == "resume" && r.Method == "POST":
+        level, err := e.Resume(id)
+        writeResult(w, level, err)
+    case len(parts) == 3 && parts[2] == "stop" && r.Method == "POST":
+        err := e.Stop(id)
+        writeResult(w, map[string]interface{}{"ok": true}, err)
+    default:
+        writeError(w, errorf(http.StatusNotFound, "no route for %s %s", r.Method, r.URL.Path))
+    }
+}
@@ -0,0 +1,225 @@
+package mock
+
+import (
+    "encoding/json"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+
+    "golang.org/x/net/websocket"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+func writeJson(w http.ResponseWriter, statusCode int, value interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(statusCode)
+    json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+    statusCode := http.StatusInternalServerError
+    if mockErr, ok := err.(*Error); ok {
+        statusCode = mockErr.StatusCode
+    }
+    writeJson(w, statusCode, map[string]interface{}{"ok": false, "error": err.Error()})
+}
+
+func writeResult(w http.ResponseWriter, value interface{}, err error) {
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    writeJson(w, http.StatusOK, value)
+}
+
+func authorized(r *http.Request) bool {
+    return r.Header.Get("X-Starfighter-Authorization") != ""
+}
+
+// ServeHTTP answers the same paths as https://api.stockfighter.io/ob/api,
+// with or without the /ob/api prefix, and the game master under /gm.
+func (e *Exchange) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    path := strings.TrimPrefix(strings.Trim(r.URL.Path, "/"), "ob/api")
+    parts := strings.Split(strings.Trim(path, "/"), "/")
+
+    if len(parts) > 0 && parts[0] == "gm" {
+        e.serveGm(w, r, parts[1:])
+        return
+    }
+    if len(parts) > 0 && parts[0] == "ws" {
+        e.serveFeed(w, r, parts[1:])
+        return
+    }
+
+    notFound := errorf(http.StatusNotFound, "no route for %s %s", r.Method, r.URL.Path)
+
+    switch {
+    case len(parts) == 1 && parts[0] == "heartbeat":
+        writeJson(w, http.StatusOK, map[string]interface{}{"ok": true, "error": ""})
+
+    case len(parts) < 3 || parts[0] != "venues":
+        writeError(w, notFound)
+
+    case len(parts) == 3 && parts[2] == "heartbeat":
+        if !e.HasVenue(parts[1]) {
+            writeError(w, errorf(http.StatusNotFound, "No venue exists with the symbol %s", parts[1]))
+            return
+        }
+        writeJson(w, http.StatusOK, map[string]interface{}{"ok": true, "venue": parts[1]})
+
+    case len(parts) == 3 && parts[2] == "stocks":
+        symbols, err := e.Stocks(parts[1])
+        writeResult(w, map[string]interface{}{"ok": true, "symbols": symbols}, err)
+
+    case len(parts) == 4 && parts[2] == "stocks":
+        book, err := e.OrderBook(parts[1], parts[3])
+        writeResult(w, book, err)
+
+    case len(parts) == 5 && parts[2] == "stocks" && parts[4] == "quote":
+        quote, err := e.Quote(parts[1], parts[3])
+        writeResult(w, quote, err)
+
+    case len(parts) == 5 && parts[2] == "stocks" && parts[4] == "orders" && r.Method == "POST":
+        e.servePlaceOrder(w, r, parts[1], parts[3])
+
+    case len(parts) == 6 && parts[2] == "stocks" && parts[4] == "orders":
+        if !authorized(r) {
+            writeError(w, errorf(http.StatusUnauthorized, "missing authorization"))
+            return
+        }
+        id, err := strconv.Atoi(parts[5])
+        if err != nil {
+            writeError(w, errorf(http.StatusBadRequest, "invalid order id %q", parts[5]))
+            return
+        }
+        var order api.Order
+        if r.Method == "DELETE" {
+            order, err = e.CancelOrder(parts[1], parts[3], id)
+        } else {
+            order, err = e.OrderStatus(parts[1], parts[3], id)
+        }
+        writeResult(w, order, err)
+
+    case parts[2] == "accounts" && (len(parts) == 5 && parts[4] == "orders" ||
+        len(parts) == 7 && parts[4] == "stocks" && parts[6] == "orders"):
+        if !authorized(r) {
+            writeError(w, errorf(http.StatusUnauthorized, "missing authorization"))
+            return
+        }
+        symbol := ""
+        if len(parts) == 7 {
+            symbol = parts[5]
+        }
+        orders, err := e.AllOrders(parts[3], parts[1], symbol)
+        writeResult(w, api.AllOrders{Ok: true, Venue: parts[1], Orders: orders}, err)
+
+    default:
+        writeError(w, notFound)
+    }
+}
+
+func (e *Exchange) servePlaceOrder(w http.ResponseWriter, r *http.Request, venue string, symbol string) {
+    if !authorized(r) {
+        writeError(w, errorf(http.StatusUnauthorized, "missing authorization"))
+        return
+    }
+
+    var request api.OrderRequest
+    body, err := ioutil.ReadAll(r.Body)
+    if err == nil {
+        err = json.Unmarshal(body, &request)
+    }
+    if err != nil {
+        writeError(w, errorf(http.StatusBadRequest, "invalid order: %v", err))
+        return
+    }
+    if request.Venue != "" && request.Venue != venue || request.Stock != "" && request.Stock != symbol {
+        writeError(w, errorf(http.StatusBadRequest, "order for %s/%s posted to %s/%s", request.Venue, request.Stock, venue, symbol))
+        return
+    }
+
+    request.Venue, request.Stock = venue, symbol
+    order, err := e.PlaceOrder(request)
+    writeResult(w, order, err)
+}
+
+// serveFeed handles ws/:account/venues/:venue/(tickertape|executions)
+// with an optional stocks/:stock suffix.
+func (e *Exchange) serveFeed(w http.ResponseWriter, r *http.Request, parts []string) {
+    if len(parts) != 4 && len(parts) != 6 || parts[1] != "venues" ||
+        parts[3] != "tickertape" && parts[3] != "executions" ||
+        len(parts) == 6 && parts[4] != "stocks" {
+        writeError(w, errorf(http.StatusNotFound, "no feed at %s", r.URL.Path))
+        return
+    }
+    if !e.HasVenue(parts[2]) {
+        writeError(w, errorf(http.StatusNotFound, "No venue exists with the symbol %s", parts[2]))
+        return
+    }
+    symbol := ""
+    if len(parts) == 6 {
+        symbol = parts[5]
+    }
+
+    websocket.Handler(func(ws *websocket.Conn) {
+        s := e.subscribe(parts[3], parts[0], parts[2], symbol)
+        defer e.unsubscribe(s)
+
+        // Clients never send anything; reading only notices when they go.
+        go func() {
+            io.Copy(ioutil.Discard, ws)
+            e.unsubscribe(s)
+        }()
+
+        for {
+            select {
+            case message := <-s.messages:
+                if err := websocket.JSON.Send(ws, message); err != nil {
+                    return
+                }
+            case <-s.closed:
+                return
+            }
+        }
+    }).ServeHTTP(w, r)
+}
+
+// Server runs an Exchange on a local port.
+type Server struct {
+    *Exchange
+    httpServer *httptest.Server
+}
+
+func NewServer(exchange *Exchange) *Server {
+    return &Server{
+        Exchange:   exchange,
+        httpServer: httptest.NewServer(exchange),
+    }
+}
+
+func (s *Server) Url() string {
+    return s.httpServer.URL
+}
+
+// Client returns an api.Client talking to this server.
+func (s *Server) Client(apiKey string) *api.Client {
+    client := api.NewClient(apiKey)
+    client.BaseUrl = s.Url()
+    return client
+}
+
+// GameMaster returns an api.GameMaster talking to this server.
+func (s *Server) GameMaster(apiKey string) *api.GameMaster {
+    gm := api.NewGameMaster(apiKey)
+    gm.BaseUrl = s.Url() + "/gm"
+    return gm
+}
+
+func (s *Server) Close() {
+    s.CloseFeeds()
+    s.httpServer.Close()
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+    "log"
+    "sort"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// OrderState is where an order is in its lifecycle, as far as the session
+// knows.
+type OrderState string
+
+const (
+    // Sent to the venue, not acknowledged yet.
+    ORDER_PENDING_NEW OrderState = "pending-new"
+    // Resting on the venue, nothing filled.
+    ORDER_NEW              OrderState = "new"
+    ORDER_PARTIALLY_FILLED OrderState = "partially-filled"
+    ORDER_FILLED           OrderState = "filled"
+    // Cancel sent, the order may still fill until the venue closes it.
+    ORDER_PENDING_CANCEL OrderState = "pending-cancel"
+    // Closed before filling entirely: cancelled, or what the book could
+    // not fill of a market order.
+    ORDER_CANCELLED OrderState = "cancelled"
+    // Turned down by the venue.
+    ORDER_REJECTED OrderState = "rejected"
+)
+
+// order_transitions lists the states each state may move to. Filled,
+// cancelled and rejected are final.
+var order_transitions = map[OrderState][]OrderState{
+    ORDER_PENDING_NEW:      {ORDER_NEW, ORDER_PARTIALLY_FILLED, ORDER_FILLED, ORDER_CANCELLED, ORDER_REJECTED},
+    ORDER_NEW:              {ORDER_PARTIALLY_FILLED, ORDER_FILLED, ORDER_PENDING_CANCEL, ORDER_CANCELLED},
+    ORDER_PARTIALLY_FILLED: {ORDER_FILLED, ORDER_PENDING_CANCEL, ORDER_CANCELLED},
+    // Back to new or partially filled when the venue turns the cancel down.
+    ORDER_PENDING_CANCEL: {ORDER_NEW, ORDER_PARTIALLY_FILLED, ORDER_FILLED, ORDER_CANCELLED},
+}
+
+// ManagedOrder is an order of the session tracked through its lifecycle.
+type ManagedOrder struct {
+    // Assigned by the session when the order is sent, or found on the
+    // venue; the venue's id is only known once it acknowledged the order.
+    ClientId int
+    State    OrderState
+    // Why the venue rejected the order.
+    Reason string
+    // Client id of the order this one replaced, when placed by an amend.
+    Replaces int
+    // Latest view of the order, the request while pending new.
+    Order api.Order
+}
+
+// Working tells whether the order may still trade.
+func (o *ManagedOrder) Working() bool {
+    switch o.State {
+    case ORDER_PENDING_NEW, ORDER_NEW, ORDER_PARTIALLY_FILLED, ORDER_PENDING_CANCEL:
+        return true
+    }
+    return false
+}
+
+// transition moves the order to state, unless the lifecycle forbids it,
+// which happens when messages about the order arrive out of order.
+func (o *ManagedOrder) transition(to OrderState) bool {
+    if o.State == to {
+        return true
+    }
+    for _, allowed := range order_transitions[o.State] {
+        if allowed == to {
+            o.State = to
+            return true
+        }
+    }
+    log.Printf("OMS: order %d (%s:%d) cannot go from %s to %s", o.ClientId, o.Order.Venue, o.Order.Id, o.State, to)
+    return false
+}
+
+// venue_state is the state the venue's view of order puts it in. A cancel
+// in flight keeps the order pending cancel until the venue closes it.
+func venue_state(current OrderState, order api.Order) OrderState {
+    switch {
+    case !order.Open && order.TotalFilled >= order.OriginalQty:
+        return ORDER_FILLED
+    case !order.Open:
+        return ORDER_CANCELLED
+    case current == ORDER_PENDING_CANCEL:
+        return ORDER_PENDING_CANCEL
+    case order.TotalFilled > 0:
+        return ORDER_PARTIALLY_FILLED
+    }
+    return ORDER_NEW
+}
+
+// newer tells whether incoming is a more recent view of the order than
+// known. Views only move forward: a fill is never undone, and a closed
+// order never reopens, so a REST response overtaken by an execution is
+// recognised as stale.
+func newer(incoming api.Order, known api.Order) bool {
+    if incoming.TotalFilled != known.TotalFilled {
+        return incoming.TotalFilled > known.TotalFilled
+    }
+    return known.Open || !incoming.Open
+}
+
+// oms_new tracks an order about to be sent.
+func oms_new(request api.Order) *ManagedOrder {
+    session.nextClientId += 1
+    managed := &ManagedOrder{ClientId: session.nextClientId, State: ORDER_PENDING_NEW, Order: request}
+    session.managed[managed.ClientId] = managed
+    return managed
+}
+
+func oms_rejected(managed *ManagedOrder, err error) {
+    if managed.transition(ORDER_REJECTED) {
+        managed.Reason = err.Error()
+    }
+}
+
+// oms_acknowledged links an order to the id the venue gave it. The order
+// may have been adopted already, from an execution or a sync that
+// overtook the venue's response, in which case the two are merged.
+func oms_acknowledged(managed *ManagedOrder, order api.Order) {
+    key := OrderKey{order.Venue, order.Id}
+    if adopted, ok := session.clientIds[key]; ok && adopted != managed.ClientId {
+        managed.Order, managed.State = session.managed[adopted].Order, session.managed[adopted].State
+        delete(session.managed, adopted)
+    }
+    session.clientIds[key] = managed.ClientId
+    oms_update(order)
+}
+
+// oms_update merges a view of one of our orders from the venue into the
+// session, and moves the order through its lifecycle. Orders the session
+// did not send, such as those of an earlier run, are adopted.
+func oms_update(order api.Order) *ManagedOrder {
+    key := OrderKey{order.Venue, order.Id}
+    clientId, ok := session.clientIds[key]
+    if !ok {
+        session.nextClientId += 1
+        clientId = session.nextClientId
+        session.clientIds[key] = clientId
+        session.managed[clientId] = &ManagedOrder{ClientId: clientId, State: ORDER_PENDING_NEW, Order: order}
+    }
+    managed := session.managed[clientId]
+    if managed.State == ORDER_PENDING_NEW || newer(order, managed.Order) {
+        managed.Order = order
+    }
+    managed.transition(venue_state(managed.State, managed.Order))
+    session.Orders[key] = managed.Order
+    return managed
+}
+
+// oms_cancelling marks an order as being cancelled. It returns false when
+// the order is not working any more.
+func oms_cancelling(venue string, id int) bool {
+    managed, ok := session.Managed(venue, id)
+    return ok && managed.Working() && managed.transition(ORDER_PENDING_CANCEL)
+}
+
+// oms_cancel_failed puts an order whose cancel the venue turned down back
+// in the state the venue last reported.
+func oms_cancel_failed(venue string, id int) {
+    if managed, ok := session.Managed(venue, id); ok && managed.State == ORDER_PENDING_CANCEL {
+        managed.transition(venue_state(ORDER_NEW, managed.Order))
+    }
+}
+
+// Managed returns the order the venue knows by id.
+func (s *Session) Managed(venue string, id int) (*ManagedOrder, bool) {
+    clientId, ok := s.clientIds[OrderKey{venue, id}]
+    if !ok {
+        return nil, false
+    }
+    return s.managed[clientId], true
+}
+
+// ClientOrder returns an order by its client id.
+func (s *Session) ClientOrder(clientId int) (*ManagedOrder, bool) {
+    managed, ok := s.managed[clientId]
+    return managed, ok
+}
+
+// Working lists the working orders of an instrument in the order they were
+// sent, on one side, or both when direction is empty.
+func (s *Session) Working(instrument Instrument, direction api.Direction) []*ManagedOrder {
+    var working []*ManagedOrder
+    for _, managed := range s.managed {
+        order := managed.Order
+        if !managed.Working() || order.Venue != instrument.Venue || order.Symbol != instrument.Symbol {
+            continue
+        }
+        if direction == "" || order.Direction == direction {
+            working = append(working, managed)
+        }
+    }
+    sort.Slice(working, func(i, j int) bool { return working[i].ClientId < working[j].ClientId })
+    return working
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+    "errors"
+    "testing"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// view is the venue's view of order id, of 100 shares, once filled shares
+// traded.
+func view(id int, filled int, open bool) api.Order {
+    return api.Order{Venue: "TESTEX", Symbol: "FOO", Direction: api.Buy, Id: id, OrderType: api.Limit,
+        OriginalQty: 100, Qty: 100 - filled, Price: 100, TotalFilled: filled, Open: open}
+}
+
+func TestOrderTransitions(t *testing.T) {
+    for _, test := range []struct {
+        from, to OrderState
+        allowed  bool
+    }{
+        {ORDER_PENDING_NEW, ORDER_NEW, true},
+        {ORDER_PENDING_NEW, ORDER_PARTIALLY_FILLED, true},
+        {ORDER_PENDING_NEW, ORDER_FILLED, true},
+        {ORDER_PENDING_NEW, ORDER_CANCELLED, true},
+        {ORDER_PENDING_NEW, ORDER_REJECTED, true},
+        {ORDER_PENDING_NEW, ORDER_PENDING_CANCEL, false},
+        {ORDER_NEW, ORDER_PARTIALLY_FILLED, true},
+        {ORDER_NEW, ORDER_FILLED, true},
+        {ORDER_NEW, ORDER_PENDING_CANCEL, true},
+        {ORDER_NEW, ORDER_CANCELLED, true},
+        {ORDER_NEW, ORDER_PENDING_NEW, false},
+        {ORDER_NEW, ORDER_REJECTED, false},
+        {ORDER_PARTIALLY_FILLED, ORDER_FILLED, true},
+        {ORDER_PARTIALLY_FILLED, ORDER_PENDING_CANCEL, true},
+        {ORDER_PARTIALLY_FILLED, ORDER_CANCELLED, true},
+        {ORDER_PARTIALLY_FILLED, ORDER_NEW, false},
+        {ORDER_PENDING_CANCEL, ORDER_NEW, true},
+        {ORDER_PENDING_CANCEL, ORDER_PARTIALLY_FILLED, true},
+        {ORDER_PENDING_CANCEL, ORDER_FILLED, true},
+        {ORDER_PENDING_CANCEL, ORDER_CANCELLED, true},
+        {ORDER_PENDING_CANCEL, ORDER_REJECTED, false},
+        {ORDER_FILLED, ORDER_CANCELLED, false},
+        {ORDER_FILLED, ORDER_PARTIALLY_FILLED, false},
+        {ORDER_CANCELLED, ORDER_NEW, false},
+        {ORDER_CANCELLED, ORDER_FILLED, false},
+        {ORDER_REJECTED, ORDER_NEW, false},
+        {ORDER_FILLED, ORDER_FILLED, true},
+    } {
+        managed := &ManagedOrder{State: test.from}
+        want := test.from
+        if test.allowed {
+            want = test.to
+        }
+        if allowed := managed.transition(test.to); allowed != test.allowed || managed.State != want {
+            t.Errorf("%s to %s: allowed %t, now %s; expected allowed %t, now %s",
+                test.from, test.to, allowed, managed.State, test.allowed, want)
+        }
+    }
+}
+
+func TestNewerView(t *testing.T) {
+    for _, test := range []struct {
+        name            string
+        incoming, known api.Order
+        newer           bool
+    }{
+        {"more filled", view(1, 50, true), view(1, 0, true), true},
+        {"fewer filled", view(1, 0, true), view(1, 50, true), false},
+        {"closed", view(1, 50, false), view(1, 50, true), true},
+        {"reopened", view(1, 50, true), view(1, 50, false), false},
+        {"same open", view(1, 50, true), view(1, 50, true), true},
+        {"same closed", view(1, 50, false), view(1, 50, false), true},
+        {"fill after the close", view(1, 60, false), view(1, 50, false), true},
+    } {
+        if newer := newer(test.incoming, test.known); newer != test.newer {
+            t.Errorf("%s: newer %t, expected %t", test.name, newer, test.newer)
+        }
+    }
+}
+
+// Each lifecycle starts from an order of 100 shares sent and acknowledged
+// as id 1.
+func TestOrderLifecycle(t *testing.T) {
+    for _, test := range []struct {
+        name   string
+        steps  func()
+        state  OrderState
+        filled int
+    }{
+        {"resting", func() {}, ORDER_NEW, 0},
+        {"partially filled then filled", func() {
+            oms_update(view(1, 30, true))
+            oms_update(view(1, 100, false))
+        }, ORDER_FILLED, 100},
+        {"partially filled then cancelled", func() {
+            oms_update(view(1, 30, true))
+            oms_cancelling("TESTEX", 1)
+            oms_update(view(1, 30, false))
+        }, ORDER_CANCELLED, 30},
+        {"filled while cancelling", func() {
+            oms_cancelling("TESTEX", 1)
+            oms_update(view(1, 100, false))
+        }, ORDER_FILLED, 100},
+        {"partially filled while cancelling", func() {
+            oms_cancelling("TESTEX", 1)
+            oms_update(view(1, 30, true))
+        }, ORDER_PENDING_CANCEL, 30},
+        {"cancel failed", func() {
+            oms_cancelling("TESTEX", 1)
+            oms_cancel_failed("TESTEX", 1)
+        }, ORDER_NEW, 0},
+        {"cancel failed after a fill", func() {
+            oms_cancelling("TESTEX", 1)
+            oms_update(view(1, 30, true))
+            oms_cancel_failed("TESTEX", 1)
+        }, ORDER_PARTIALLY_FILLED, 30},
+        {"cancel failed once closed", func() {
+            oms_update(view(1, 100, false))
+            oms_cancelling("TESTEX", 1)
+            oms_cancel_failed("TESTEX", 1)
+        }, ORDER_FILLED, 100},
+        {"stale view dropped", func() {
+            oms_update(view(1, 30, true))
+            oms_update(view(1, 0, true))
+        }, ORDER_PARTIALLY_FILLED, 30},
+        {"closed order never reopens", func() {
+            oms_update(view(1, 30, false))
+            oms_update(view(1, 30, true))
+        }, ORDER_CANCELLED, 30},
+    } {
+        session = Session{Account: "ME", Instruments: []Instrument{{"TESTEX", "FOO"}}, Mark: MARK_LAST}
+        if err := init_session(); err != nil {
+            t.Fatal(err)
+        }
+        managed := oms_new(view(0, 0, true))
+        oms_acknowledged(managed, view(1, 0, true))
+        test.steps()
+        if managed.State != test.state || managed.Order.TotalFilled != test.filled {
+            t.Errorf("%s: %s with %d filled, expected %s with %d filled",
+                test.name, managed.State, managed.Order.TotalFilled, test.state, test.filled)
+        }
+        if order := session.Orders[OrderKey{"TESTEX", 1}]; order.TotalFilled != managed.Order.TotalFilled ||
+            order.Open != managed.Order.Open {
+            t.Errorf("%s: the session's view %+v is not the managed order's", test.name, order)
+        }
+    }
+}
+
+func TestOrderRejected(t *testing.T) {
+    session = Session{Account: "ME", Instruments: []Instrument{{"TESTEX", "FOO"}}, Mark: MARK_LAST}
+    if err := init_session(); err != nil {
+        t.Fatal(err)
+    }
+    managed := oms_new(view(0, 0, true))
+    oms_rejected(managed, errors.New("price too far"))
+    if managed.State != ORDER_REJECTED || managed.Reason != "price too far" || managed.Working() {
+        t.Fatalf("expected a rejected order, got %+v", managed)
+    }
+}
+
+// An execution overtaking the venue's response to the order adopts it;
+// the response then merges the adopted order into the one sent.
+func TestAcknowledgedAfterAdoption(t *testing.T) {
+    session = Session{Account: "ME", Instruments: []Instrument{{"TESTEX", "FOO"}}, Mark: MARK_LAST}
+    if err := init_session(); err != nil {
+        t.Fatal(err)
+    }
+    managed := oms_new(view(0, 0, true))
+    adopted := oms_update(view(1, 30, true))
+    if adopted == managed || adopted.State != ORDER_PARTIALLY_FILLED {
+        t.Fatalf("expected the order adopted apart, got %+v", adopted)
+    }
+
+    // The response left the venue before the fill.
+    oms_acknowledged(managed, view(1, 0, true))
+    if found, ok := session.Managed("TESTEX", 1); !ok || found != managed {
+        t.Fatalf("expected order 1 to be the one sent, got %+v", found)
+    }
+    if _, ok := session.ClientOrder(adopted.ClientId); ok || len(session.managed) != 1 {
+        t.Fatalf("expected the adopted order merged, got %d orders", len(session.managed))
+    }
+    if managed.State != ORDER_PARTIALLY_FILLED || managed.Order.TotalFilled != 30 {
+        t.Fatalf("expected the fill kept, got %s with %d filled", managed.State, managed.Order.TotalFilled)
+    }
+}
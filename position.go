@@ -0,0 +1,111 @@
+package main
+
+import (
+    "log"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// FillKey identifies a fill the same way whether it comes from an
+// Executions message or from the fills of an order fetched over REST, so
+// each fill moves the position once: by its place among the fills of the
+// order. Nothing else tells fills apart, an order sweeping two resting
+// orders of the same size gets two fills of the same price, qty and time.
+type FillKey struct {
+    Venue   string
+    OrderId int
+    Index   int
+}
+
+// fill_position moves pos by a fill, keeping the average cost of the
+// shares held and realising the PnL of those a fill closes.
+func fill_position(pos *Position, direction api.Direction, qty int, price int) {
+    signed := qty
+    if direction == api.Buy {
+        pos.Balance -= price * qty
+    } else {
+        pos.Balance += price * qty
+        signed = -qty
+    }
+
+    if pos.Owned == 0 || (pos.Owned > 0) == (signed > 0) {
+        // Opening or adding to the position.
+        pos.AvgCost = (pos.AvgCost*float64(abs(pos.Owned)) + float64(price*qty)) / float64(abs(pos.Owned)+qty)
+        pos.Owned += signed
+        return
+    }
+
+    closed := qty
+    if closed > abs(pos.Owned) {
+        closed = abs(pos.Owned)
+    }
+    if pos.Owned > 0 {
+        pos.Realised += int(float64(closed) * (float64(price) - pos.AvgCost))
+    } else {
+        pos.Realised += int(float64(closed) * (pos.AvgCost - float64(price)))
+    }
+    pos.Owned += signed
+    switch {
+    case pos.Owned == 0:
+        pos.AvgCost = 0
+    case closed < qty:
+        // The fill flipped the position, what is left was opened at price.
+        pos.AvgCost = float64(price)
+    }
+}
+
+func abs(n int) int {
+    if n < 0 {
+        return -n
+    }
+    return n
+}
+
+// apply_fill moves the position of market by a fill of one of our orders,
+// unless the fill was applied already. It reports whether it was new.
+func apply_fill(market *Market, direction api.Direction, key FillKey, qty int, price int) bool {
+    if session.fills[key] {
+        return false
+    }
+    session.fills[key] = true
+    if market != nil {
+        fill_position(&market.Position, direction, qty, price)
+        mark_position(market, time.Now())
+    }
+    return true
+}
+
+// update_order stores the latest state of one of our orders and applies
+// the fills not seen yet. It returns their indices among the order's fills.
+func update_order(order api.Order) []int {
+    oms_update(order)
+    // Orders of instruments the session does not trade carry no position.
+    market := session.Market(order.Venue, order.Symbol)
+    var applied []int
+    for i, fill := range order.Fills {
+        if apply_fill(market, order.Direction, FillKey{order.Venue, order.Id, i}, fill.Qty, fill.Price) {
+            applied = append(applied, i)
+        }
+    }
+    return applied
+}
+
+// update_executions applies the fill an Executions message reports. The
+// executions feed is what keeps positions current; sync_orders only
+// reconciles them with the venue. The message carries the order as the
+// fill left it, so the fill is the last of the order's fills.
+func update_executions(executions api.Executions) {
+    order := executions.Order
+    oms_update(order)
+    apply_fill(session.Market(executions.Venue, executions.Symbol), order.Direction,
+        FillKey{executions.Venue, order.Id, len(order.Fills) - 1}, executions.Filled, executions.Price)
+}
+
+// report_missed_fills logs the fills a reconciliation had to apply, which
+// the executions feed should have delivered.
+func report_missed_fills(order api.Order, missed []int) {
+    if len(missed) > 0 && session.synced {
+        log.Printf("Reconciled %d fills of order %s:%d missed by the executions feed", len(missed), order.Venue, order.Id)
+    }
+}
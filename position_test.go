@@ -0,0 +1,78 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+    "github.com/vincenzoauteri/stockfighter/mock"
+)
+
+// sweep has our account buy 100 shares at 100 into two asks of 50 shares
+// at the same price. The exchange clock is frozen, so the two fills of the
+// order are alike in everything but their place among its fills.
+func sweep(t *testing.T) (*Market, api.Order) {
+    exchange := mock.NewExchange()
+    exchange.AddVenue("TESTEX", "FOO")
+    frozen := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+    exchange.Now = func() time.Time { return frozen }
+
+    session = Session{Account: "ME", Instruments: []Instrument{{"TESTEX", "FOO"}}, Mark: MARK_LAST}
+    if err := init_session(); err != nil {
+        t.Fatal(err)
+    }
+    for i := 0; i < 2; i++ {
+        if _, err := exchange.PlaceOrder(api.OrderRequest{Account: "OTHER", Venue: "TESTEX", Stock: "FOO",
+            Price: 100, Qty: 50, Direction: api.Sell, OrderType: api.Limit}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    order, err := exchange.PlaceOrder(api.OrderRequest{Account: "ME", Venue: "TESTEX", Stock: "FOO",
+        Price: 100, Qty: 100, Direction: api.Buy, OrderType: api.Limit})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(order.Fills) != 2 || order.Fills[0] != order.Fills[1] {
+        t.Fatalf("expected two identical fills, got %+v", order.Fills)
+    }
+    return session.Primary(), order
+}
+
+func check_position(t *testing.T, market *Market, owned int, balance int) {
+    t.Helper()
+    if pos := market.Position; pos.Owned != owned || pos.Balance != balance {
+        t.Fatalf("expected owned %d cash %d, got owned %d cash %d", owned, balance, pos.Owned, pos.Balance)
+    }
+}
+
+func TestIdenticalFillsOfOrder(t *testing.T) {
+    market, order := sweep(t)
+    if applied := len(update_order(order)); applied != 2 {
+        t.Fatalf("expected 2 new fills, got %d", applied)
+    }
+    check_position(t, market, 100, -10000)
+
+    // Seen again, the order moves nothing.
+    if applied := len(update_order(order)); applied != 0 {
+        t.Fatalf("expected no new fill, got %d", applied)
+    }
+    check_position(t, market, 100, -10000)
+}
+
+func TestIdenticalExecutions(t *testing.T) {
+    market, order := sweep(t)
+    for i, fill := range order.Fills {
+        // Each message carries the order as its fill left it.
+        state := order
+        state.Fills = order.Fills[:i+1]
+        update_executions(api.Executions{Account: "ME", Venue: "TESTEX", Symbol: "FOO", Order: state,
+            Price: fill.Price, Filled: fill.Qty, FilledAt: fill.Ts})
+    }
+    check_position(t, market, 100, -10000)
+
+    // The same fills found by a sync are not applied twice.
+    if applied := len(update_order(order)); applied != 0 {
+        t.Fatalf("expected no new fill, got %d", applied)
+    }
+    check_position(t, market, 100, -10000)
+}
@@ -0,0 +1,162 @@
+// Package recording stores the market data of a session as JSON lines,
+// one message per line with the time it was received, so levels can be
+// studied and replayed afterwards.
+package recording
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// Kinds of recorded messages.
+const (
+    KindQuote     = "quote"
+    KindExecution = "execution"
+    KindBook      = "book"
+)
+
+// Record is one line of a recording.
+type Record struct {
+    Received time.Time       `json:"received"`
+    Kind     string          `json:"kind"`
+    Data     json.RawMessage `json:"data"`
+}
+
+func kind(message interface{}) (string, error) {
+    switch message.(type) {
+    case api.StockQuoteWs:
+        return KindQuote, nil
+    case api.Executions:
+        return KindExecution, nil
+    case api.OrderBook:
+        return KindBook, nil
+    }
+    return "", fmt.Errorf("cannot record %T", message)
+}
+
+// Writer appends messages to a recording. Writes are buffered until Flush
+// or Close.
+type Writer struct {
+    Path string
+
+    file   *os.File
+    buffer *bufio.Writer
+}
+
+// Create starts a new recording in dir, named after the session and the
+// time it starts so every session gets a file of its own.
+func Create(dir string, session string, start time.Time) (*Writer, error) {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, err
+    }
+    path := filepath.Join(dir, fmt.Sprintf("%s-%s.jsonl", session, start.UTC().Format("20060102T150405Z")))
+    file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND|os.O_EXCL, 0644)
+    if err != nil {
+        return nil, err
+    }
+    return &Writer{Path: path, file: file, buffer: bufio.NewWriter(file)}, nil
+}
+
+// Write records a StockQuoteWs, Executions or OrderBook message.
+func (w *Writer) Write(received time.Time, message interface{}) error {
+    kind, err := kind(message)
+    if err != nil {
+        return err
+    }
+    data, err := json.Marshal(message)
+    if err != nil {
+        return err
+    }
+    line, err := json.Marshal(Record{Received: received, Kind: kind, Data: data})
+    if err != nil {
+        return err
+    }
+    line = append(line, '\n')
+    _, err = w.buffer.Write(line)
+    return err
+}
+
+func (w *Writer) Flush() error {
+    return w.buffer.Flush()
+}
+
+func (w *Writer) Close() error {
+    if err := w.buffer.Flush(); err != nil {
+        w.file.Close()
+        return err
+    }
+    return w.file.Close()
+}
+
+// Reader reads a recording back, in the order it was written.
+type Reader struct {
+    file    *os.File
+    scanner *bufio.Scanner
+    line    int
+}
+
+func Open(path string) (*Reader, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    scanner := bufio.NewScanner(file)
+    // Order books of busy stocks make for long lines.
+    scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    return &Reader{file: file, scanner: scanner}, nil
+}
+
+// Next returns the next message, decoded to the api type it was recorded
+// from, and the time it was received. It returns io.EOF at the end, which
+// may be a truncated line.
+func (r *Reader) Next() (time.Time, interface{}, error) {
+    if !r.scanner.Scan() {
+        if err := r.scanner.Err(); err != nil {
+            return time.Time{}, nil, err
+        }
+        return time.Time{}, nil, io.EOF
+    }
+    r.line += 1
+
+    var record Record
+    if err := json.Unmarshal(r.scanner.Bytes(), &record); err != nil {
+        // A recorder killed mid-write leaves a truncated last line.
+        if !r.scanner.Scan() && r.scanner.Err() == nil {
+            return time.Time{}, nil, io.EOF
+        }
+        return time.Time{}, nil, fmt.Errorf("line %d: %v", r.line, err)
+    }
+    var message interface{}
+    var err error
+    switch record.Kind {
+    case KindQuote:
+        var quote api.StockQuoteWs
+        err = json.Unmarshal(record.Data, &quote)
+        message = quote
+    case KindExecution:
+        var execution api.Executions
+        err = json.Unmarshal(record.Data, &execution)
+        message = execution
+    case KindBook:
+        var book api.OrderBook
+        err = json.Unmarshal(record.Data, &book)
+        message = book
+    default:
+        err = fmt.Errorf("unknown kind %q", record.Kind)
+    }
+    if err != nil {
+        return time.Time{}, nil, fmt.Errorf("line %d: %v", r.line, err)
+    }
+    return record.Received, message, nil
+}
+
+func (r *Reader) Close() error {
+    return r.file.Close()
+}
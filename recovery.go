@@ -0,0 +1,109 @@
+package main
+
+import (
+    "bytes"
+    "database/sql"
+    "fmt"
+    "log"
+    "time"
+)
+
+// recover_session rebuilds the session on startup. Orders and positions
+// come from the venue, which is trusted, but they are first compared with
+// what the database recorded before the bot last stopped: every difference
+// is logged, then the database is brought in line with the venue.
+func recover_session(now time.Time) error {
+    if globals.store == nil {
+        return sync_orders()
+    }
+    saved, err := globals.store.LoadOrders(session.Account)
+    if err != nil {
+        return fmt.Errorf("load recorded orders: %v", err)
+    }
+    positions, err := globals.store.LoadPositions()
+    if err != nil {
+        return fmt.Errorf("load recorded positions: %v", err)
+    }
+
+    if err := sync_orders(); err != nil {
+        return err
+    }
+
+    discrepancies := 0
+    flag := func(format string, args ...interface{}) {
+        discrepancies += 1
+        log.Printf("Recovery: "+format, args...)
+    }
+
+    recorded := make(map[OrderKey]bool)
+    for _, order := range saved {
+        if session.Market(order.Venue, order.Symbol) == nil {
+            continue
+        }
+        recorded[OrderKey{order.Venue, order.Id}] = true
+        current, ok := session.Order(order.Venue, order.Id)
+        if !ok {
+            flag("order %s:%d recorded but unknown to the venue", order.Venue, order.Id)
+            continue
+        }
+        if current.Open != order.Open || current.TotalFilled != order.TotalFilled {
+            flag("order %s:%d recorded open:%t filled:%d, venue has open:%t filled:%d", order.Venue, order.Id,
+                order.Open, order.TotalFilled, current.Open, current.TotalFilled)
+        }
+    }
+    for key := range session.Orders {
+        if !recorded[key] {
+            flag("order %s:%d on the venue was never recorded", key.Venue, key.Id)
+        }
+    }
+
+    for instrument, position := range positions {
+        market, ok := session.Markets[instrument]
+        if !ok {
+            continue
+        }
+        if market.Position.Owned != position.Owned || market.Position.Balance != position.Balance {
+            flag("%s recorded owned:%d cash:%d, venue orders give owned:%d cash:%d", instrument,
+                position.Owned, position.Balance, market.Position.Owned, market.Position.Balance)
+        }
+    }
+
+    log.Printf("Recovery: %d orders and %d positions recorded, %d discrepancies", len(saved), len(positions), discrepancies)
+    // sync_orders recorded the orders; record the positions they give.
+    return record_positions(now)
+}
+
+// restore_strategy hands a Stateful strategy the state it last saved under
+// name for the session's account.
+func restore_strategy(name string, strategy Strategy) error {
+    stateful, ok := strategy.(Stateful)
+    if !ok || globals.store == nil {
+        return nil
+    }
+    state, err := globals.store.LoadStrategyState(session.Account, name)
+    if err != nil || state == nil {
+        return err
+    }
+    log.Printf("Recovery: restoring %s state %s", name, state)
+    return stateful.RestoreState(state)
+}
+
+// save_strategy saves the state of a Stateful strategy unless it is the
+// state saved last, and returns the state now saved.
+func save_strategy(now time.Time, name string, strategy Strategy, saved []byte) ([]byte, error) {
+    stateful, ok := strategy.(Stateful)
+    if !ok {
+        return saved, nil
+    }
+    state, err := stateful.SaveState()
+    if err != nil || bytes.Equal(state, saved) {
+        return saved, err
+    }
+    err = in_transaction(func(tx *sql.Tx) error {
+        return globals.store.SaveStrategyState(tx, now, session.Account, name, state)
+    })
+    if err != nil {
+        return saved, err
+    }
+    return state, nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+    "database/sql"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// Repository reads and writes the account database through statements
+// prepared once when it is opened. Writes take the transaction they belong
+// to, so callers decide what is committed together.
+type Repository struct {
+    db *sql.DB
+
+    saveOrder        *sql.Stmt
+    saveFill         *sql.Stmt
+    savePosition     *sql.Stmt
+    snapshotPosition *sql.Stmt
+    loadPositions    *sql.Stmt
+    loadOrders       *sql.Stmt
+    saveState        *sql.Stmt
+    loadState        *sql.Stmt
+    saveKill         *sql.Stmt
+    loadKill         *sql.Stmt
+    saveDayStart     *sql.Stmt
+    loadDayStart     *sql.Stmt
+    forgetDayStarts  *sql.Stmt
+}
+
+func NewRepository(db *sql.DB) (*Repository, error) {
+    r := &Repository{db: db}
+    for _, statement := range []struct {
+        stmt  **sql.Stmt
+        query string
+    }{
+        {&r.saveOrder, `INSERT INTO orders
+            (venue, id, account, symbol, direction, type, price, original_qty, qty, filled, open, ts, updated_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+            ON CONFLICT (venue, id) DO UPDATE SET
+            qty = excluded.qty, filled = excluded.filled, open = excluded.open, updated_at = excluded.updated_at;`},
+        {&r.saveFill, `INSERT INTO fills
+            (venue, symbol, account, order_id, fill_index, standing_id, incoming_id, price, qty, filled_at,
+            standing_complete, incoming_complete)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+            ON CONFLICT (venue, order_id, fill_index) DO UPDATE SET
+            standing_id = coalesce(fills.standing_id, excluded.standing_id),
+            incoming_id = coalesce(fills.incoming_id, excluded.incoming_id),
+            standing_complete = coalesce(fills.standing_complete, excluded.standing_complete),
+            incoming_complete = coalesce(fills.incoming_complete, excluded.incoming_complete);`},
+        {&r.savePosition, `INSERT INTO positions (venue, symbol, owned, balance, nav, avg_cost, realised, unrealised, updated_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+            ON CONFLICT (venue, symbol) DO UPDATE SET
+            owned = excluded.owned, balance = excluded.balance, nav = excluded.nav, avg_cost = excluded.avg_cost,
+            realised = excluded.realised, unrealised = excluded.unrealised, updated_at = excluded.updated_at;`},
+        {&r.snapshotPosition, `INSERT INTO position_snapshots
+            (ts, venue, symbol, owned, balance, nav, avg_cost, realised, unrealised, mark, mark_price, quote_age_ms)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`},
+        {&r.loadPositions, `SELECT venue, symbol, owned, balance, nav, avg_cost, realised, unrealised FROM positions;`},
+        {&r.loadOrders, `SELECT venue, id, account, symbol, direction, type, price, original_qty, qty, filled, open, ts
+            FROM orders WHERE account = ?;`},
+        {&r.saveState, `INSERT INTO strategy_state (account, strategy, state, updated_at)
+            VALUES (?, ?, ?, ?)
+            ON CONFLICT (account, strategy) DO UPDATE SET
+            state = excluded.state, updated_at = excluded.updated_at;`},
+        {&r.loadState, `SELECT state FROM strategy_state WHERE account = ? AND strategy = ?;`},
+        {&r.saveKill, `INSERT INTO kill_switch (account, engaged, reason, updated_at)
+            VALUES (?, ?, ?, ?)
+            ON CONFLICT (account) DO UPDATE SET
+            engaged = excluded.engaged, reason = excluded.reason, updated_at = excluded.updated_at;`},
+        {&r.loadKill, `SELECT engaged, reason FROM kill_switch WHERE account = ?;`},
+        {&r.saveDayStart, `INSERT INTO day_start (account, instance, day, nav, started_at)
+            VALUES (?, ?, ?, ?, ?)
+            ON CONFLICT (account, instance, day) DO NOTHING;`},
+        {&r.loadDayStart, `SELECT nav FROM day_start WHERE account = ? AND instance = ? AND day = ?;`},
+        {&r.forgetDayStarts, `DELETE FROM day_start WHERE account = ? AND instance = ?;`},
+    } {
+        stmt, err := db.Prepare(statement.query)
+        if err != nil {
+            r.Close()
+            return nil, err
+        }
+        *statement.stmt = stmt
+    }
+    return r, nil
+}
+
+func (r *Repository) Close() error {
+    for _, stmt := range []*sql.Stmt{r.saveOrder, r.saveFill, r.savePosition, r.snapshotPosition, r.loadPositions, r.loadOrders, r.saveState, r.loadState, r.saveKill, r.loadKill, r.saveDayStart, r.loadDayStart, r.forgetDayStarts} {
+        if stmt != nil {
+            stmt.Close()
+        }
+    }
+    return r.db.Close()
+}
+
+// Transaction runs f in a transaction, committed only if f succeeds.
+func (r *Repository) Transaction(f func(tx *sql.Tx) error) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+    if err := f(tx); err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit()
+}
+
+func timestamp(t time.Time) string {
+    return t.UTC().Format(time.RFC3339Nano)
+}
+
+// SaveOrder inserts the order or updates what may change once it is
+// placed: its remaining quantity, fills and whether it is open.
+func (r *Repository) SaveOrder(tx *sql.Tx, order api.Order) error {
+    _, err := tx.Stmt(r.saveOrder).Exec(
+        order.Venue, order.Id, order.Account, order.Symbol, order.Direction, order.OrderType,
+        order.Price, order.OriginalQty, order.Qty, order.TotalFilled, btoi(order.Open), order.Ts,
+        timestamp(time.Now()))
+    return err
+}
+
+// SaveFill records the fill of order at index among its fills once: feeds
+// may replay executions after a reconnection, and sync_orders finds the
+// fills the executions feed delivered again. Only an execution tells the
+// orders that traded, a fill found over REST has no standing and incoming
+// ids until its execution, if any, comes along. When both sides of a trade
+// are ours, each order keeps its own row.
+func (r *Repository) SaveFill(tx *sql.Tx, order api.Order, index int, execution *api.Executions) error {
+    var standingId, incomingId, standingComplete, incomingComplete interface{}
+    fill := api.Fill{}
+    if execution != nil {
+        fill = api.Fill{Price: execution.Price, Qty: execution.Filled, Ts: execution.FilledAt}
+        standingId, incomingId = execution.StandingId, execution.IncomingId
+        standingComplete, incomingComplete = btoi(execution.StandingComplete), btoi(execution.IncomingComplete)
+    } else {
+        fill = order.Fills[index]
+    }
+    _, err := tx.Stmt(r.saveFill).Exec(
+        order.Venue, order.Symbol, order.Account, order.Id, index, standingId, incomingId,
+        fill.Price, fill.Qty, fill.Ts, standingComplete, incomingComplete)
+    return err
+}
+
+// CheckpointPosition saves the latest position of the instrument, and
+// appends it to its history with the valuation it was last marked at.
+func (r *Repository) CheckpointPosition(tx *sql.Tx, now time.Time, instrument Instrument, position Position, valuation Valuation) error {
+    ts := timestamp(now)
+    _, err := tx.Stmt(r.savePosition).Exec(
+        instrument.Venue, instrument.Symbol, position.Owned, position.Balance, position.NAV,
+        position.AvgCost, position.Realised, position.Unrealised, ts)
+    if err != nil {
+        return err
+    }
+    _, err = tx.Stmt(r.snapshotPosition).Exec(
+        ts, instrument.Venue, instrument.Symbol, position.Owned, position.Balance, position.NAV,
+        position.AvgCost, position.Realised, position.Unrealised,
+        valuation.Mark, valuation.Price, int64(valuation.QuoteAge/time.Millisecond))
+    return err
+}
+
+// LoadPositions returns the last checkpointed position of each instrument.
+func (r *Repository) LoadPositions() (map[Instrument]Position, error) {
+    rows, err := r.loadPositions.Query()
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    positions := make(map[Instrument]Position)
+    for rows.Next() {
+        var instrument Instrument
+        var position Position
+        if err := rows.Scan(&instrument.Venue, &instrument.Symbol, &position.Owned, &position.Balance, &position.NAV,
+            &position.AvgCost, &position.Realised, &position.Unrealised); err != nil {
+            return nil, err
+        }
+        position.Stock = instrument.Symbol
+        positions[instrument] = position
+    }
+    return positions, rows.Err()
+}
+
+// LoadOrders returns the recorded orders of account, without their fills.
+func (r *Repository) LoadOrders(account string) ([]api.Order, error) {
+    rows, err := r.loadOrders.Query(account)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var orders []api.Order
+    for rows.Next() {
+        var order api.Order
+        var open int
+        err := rows.Scan(&order.Venue, &order.Id, &order.Account, &order.Symbol, &order.Direction, &order.OrderType,
+            &order.Price, &order.OriginalQty, &order.Qty, &order.TotalFilled, &open, &order.Ts)
+        if err != nil {
+            return nil, err
+        }
+        order.Ok = true
+        order.Open = open != 0
+        orders = append(orders, order)
+    }
+    return orders, rows.Err()
+}
+
+func (r *Repository) SaveStrategyState(tx *sql.Tx, now time.Time, account string, strategy string, state []byte) error {
+    _, err := tx.Stmt(r.saveState).Exec(account, strategy, string(state), timestamp(now))
+    return err
+}
+
+// LoadStrategyState returns the state last saved by the strategy for
+// account, or nil if it never saved one.
+func (r *Repository) LoadStrategyState(account string, strategy string) ([]byte, error) {
+    var state string
+    err := r.loadState.QueryRow(account, strategy).Scan(&state)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return []byte(state), nil
+}
+
+// SetKillSwitch engages or releases the kill switch of account.
+func (r *Repository) SetKillSwitch(tx *sql.Tx, now time.Time, account string, engaged bool, reason string) error {
+    _, err := tx.Stmt(r.saveKill).Exec(account, engaged, reason, timestamp(now))
+    return err
+}
+
+// KillSwitch tells whether the kill switch of account is engaged, and why.
+func (r *Repository) KillSwitch(account string) (bool, string, error) {
+    var engaged bool
+    var reason string
+    err := r.loadKill.QueryRow(account).Scan(&engaged, &reason)
+    if err == sql.ErrNoRows {
+        return false, "", nil
+    }
+    return engaged, reason, err
+}
+
+// DayStart records nav as the NAV account started trading day of the level
+// instance at, unless the day started already, and returns the NAV it
+// started at. Sessions trading no instance use instance 0.
+func (r *Repository) DayStart(tx *sql.Tx, now time.Time, account string, instance int, day int, nav int) (int, error) {
+    if _, err := tx.Stmt(r.saveDayStart).Exec(account, instance, day, nav, timestamp(now)); err != nil {
+        return 0, err
+    }
+    err := tx.Stmt(r.loadDayStart).QueryRow(account, instance, day).Scan(&nav)
+    return nav, err
+}
+
+// ForgetDayStarts deletes the day starts of a level instance.
+func (r *Repository) ForgetDayStarts(tx *sql.Tx, account string, instance int) error {
+    _, err := tx.Stmt(r.forgetDayStarts).Exec(account, instance)
+    return err
+}
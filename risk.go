@@ -0,0 +1,282 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// RiskLimits bound the orders strategies may send. A zero limit is not
+// enforced.
+type RiskLimits struct {
+    // Shares held per symbol, long or short, counting the open orders on
+    // the same side as if they filled.
+    MaxPosition int `json:"maxPosition"`
+    MaxOrderQty int `json:"maxOrderQty"`
+    // Value of a single order, in cents.
+    MaxNotional int `json:"maxNotional"`
+    // Furthest a limit price may go through the opposite side of the book,
+    // or the last trade when that side is empty, in percent of it. Prices
+    // further from the market are never collared.
+    CollarPercent float64 `json:"collarPercent"`
+    // Open orders of the session across every symbol.
+    MaxOpenOrders int `json:"maxOpenOrders"`
+    // Loss of the session's NAV since the trading day started, in cents,
+    // past which no order is sent until the next day.
+    MaxDailyLoss int `json:"maxDailyLoss"`
+    // Cut orders over the size, notional or position limits down to what
+    // they allow instead of rejecting them.
+    Clip bool `json:"clip"`
+}
+
+func default_risk_limits() RiskLimits {
+    return RiskLimits{
+        MaxPosition:   1000,
+        MaxOrderQty:   1000,
+        CollarPercent: 10,
+        MaxOpenOrders: 20,
+        Clip:          true,
+    }
+}
+
+// parse_risk_limits overrides the default limits with the fields present
+// in the JSON config.
+func parse_risk_limits(config []byte) (RiskLimits, error) {
+    limits := default_risk_limits()
+    if len(config) > 0 {
+        if err := json.Unmarshal(config, &limits); err != nil {
+            return limits, fmt.Errorf("risk limits: %v", err)
+        }
+    }
+    return limits, nil
+}
+
+// risk_gateway checks every order against the limits before passing it on
+// to the venue. Cancels always go through.
+type risk_gateway struct {
+    next   OrderGateway
+    limits RiskLimits
+    clock  func() time.Time
+
+    // Trading day the daily loss is counted over, and the NAV it started
+    // at.
+    day      int
+    startNAV int
+}
+
+func new_risk_gateway(next OrderGateway, limits RiskLimits, clock func() time.Time) *risk_gateway {
+    return &risk_gateway{next: next, limits: limits, clock: clock, day: -1}
+}
+
+// A rejection looks like the venue turning the order down, so strategies
+// handle both alike.
+func risk_rejected(format string, args ...interface{}) error {
+    message := fmt.Sprintf(format, args...)
+    log.Printf("Risk: rejected %s", message)
+    return &api.Error{Method: "POST", Path: "risk", StatusCode: http.StatusBadRequest, Message: message}
+}
+
+// reference is the price an order of market in direction would trade at
+// now, 0 without a quote.
+func reference(market *Market, direction api.Direction) int {
+    quote := market.Quotes.last
+    price := quote.Ask
+    if direction != api.Buy {
+        price = quote.Bid
+    }
+    if price == 0 {
+        price = quote.Last
+    }
+    return price
+}
+
+// trading_day numbers the day the session trades in at now: the level's
+// trading day when it trades an instance, else the calendar day.
+func (g *risk_gateway) trading_day(now time.Time) int {
+    if session.InstanceId != 0 {
+        return session.Instance.Details.TradingDay
+    }
+    year, month, day := now.Date()
+    return (year*100+int(month))*100 + day
+}
+
+func session_nav() int {
+    nav := 0
+    for _, market := range session.Markets {
+        nav += market.Position.NAV
+    }
+    return nav
+}
+
+// valued tells whether every position held has a price, without which the
+// session's NAV only counts its cash.
+func valued() bool {
+    for _, market := range session.Markets {
+        if market.Position.Owned != 0 && market.Valuation.Price == 0 {
+            return false
+        }
+    }
+    return true
+}
+
+// start_day takes the NAV the trading day of now started at. The first run
+// to value the positions that day records it in the account database, so
+// a restart keeps counting the losses made before it. The engine calls it
+// on every poll, so losses made before the first order of the day count
+// too.
+func (g *risk_gateway) start_day(now time.Time) error {
+    day := g.trading_day(now)
+    if day == g.day || !valued() {
+        return nil
+    }
+    nav := session_nav()
+    err := in_transaction(func(tx *sql.Tx) error {
+        var err error
+        nav, err = globals.store.DayStart(tx, now, session.Account, session.InstanceId, day, nav)
+        return err
+    })
+    if err != nil {
+        return fmt.Errorf("start of trading day %d: %v", day, err)
+    }
+    g.day, g.startNAV = day, nav
+    log.Printf("Risk: trading day %d started at a NAV of %.2f", day, float64(nav)/100.0)
+    return nil
+}
+
+// daily_loss is how much the session lost since the trading day started,
+// nothing until its start is known.
+func (g *risk_gateway) daily_loss() (int, error) {
+    now := g.clock()
+    if err := g.start_day(now); err != nil {
+        return 0, err
+    }
+    if g.day != g.trading_day(now) {
+        return 0, nil
+    }
+    return g.startNAV - session_nav(), nil
+}
+
+// exposure is the position market would have if every open order of ours
+// on the side of direction filled.
+func exposure(market *Market, direction api.Direction) int {
+    position := market.Position.Owned
+    for _, managed := range session.Working(market.Instrument, direction) {
+        if direction == api.Buy {
+            position += managed.Order.Qty
+        } else {
+            position -= managed.Order.Qty
+        }
+    }
+    return position
+}
+
+func open_orders() int {
+    open := 0
+    for _, managed := range session.managed {
+        if managed.Working() {
+            open += 1
+        }
+    }
+    return open
+}
+
+// clip cuts qty down to max shares, or rejects the order when the limits
+// do not allow clipping or leave nothing to send.
+func (g *risk_gateway) clip(market *Market, direction api.Direction, qty int, max int, limit string) (int, error) {
+    if qty <= max {
+        return qty, nil
+    }
+    if !g.limits.Clip || max <= 0 {
+        return 0, risk_rejected("%s %s %d shares: over the %s", market.Instrument, direction, qty, limit)
+    }
+    log.Printf("Risk: clipped %s %s from %d to %d shares: over the %s", market.Instrument, direction, qty, max, limit)
+    return max, nil
+}
+
+func (g *risk_gateway) check(market *Market, direction api.Direction, qty int, price int, orderType api.OrderType) (int, error) {
+    limits := g.limits
+    if qty <= 0 {
+        return 0, risk_rejected("%s %s %d shares: no quantity", market.Instrument, direction, qty)
+    }
+    if limits.MaxDailyLoss > 0 {
+        loss, err := g.daily_loss()
+        if err != nil {
+            return 0, err
+        }
+        if loss >= limits.MaxDailyLoss {
+            return 0, risk_rejected("%s %s: lost %.2f today, over the daily loss limit", market.Instrument, direction, float64(loss)/100.0)
+        }
+    }
+    if limits.MaxOpenOrders > 0 && open_orders() >= limits.MaxOpenOrders {
+        return 0, risk_rejected("%s %s: %d orders open already", market.Instrument, direction, limits.MaxOpenOrders)
+    }
+
+    ref := reference(market, direction)
+    if orderType.Priced() {
+        if price <= 0 {
+            return 0, risk_rejected("%s %s at %d: no price", market.Instrument, direction, price)
+        }
+        if limits.CollarPercent > 0 {
+            if ref == 0 {
+                return 0, risk_rejected("%s %s at %.2f: no quote to collar the price against", market.Instrument, direction, float64(price)/100.0)
+            }
+            through := price - ref
+            if direction != api.Buy {
+                through = ref - price
+            }
+            if float64(through) > float64(ref)*limits.CollarPercent/100 {
+                return 0, risk_rejected("%s %s at %.2f: more than %.1f%% through %.2f", market.Instrument, direction,
+                    float64(price)/100.0, limits.CollarPercent, float64(ref)/100.0)
+            }
+        }
+        ref = price
+    }
+
+    var err error
+    if limits.MaxOrderQty > 0 {
+        if qty, err = g.clip(market, direction, qty, limits.MaxOrderQty, "order size limit"); err != nil {
+            return 0, err
+        }
+    }
+    if limits.MaxNotional > 0 {
+        if ref == 0 {
+            return 0, risk_rejected("%s %s: no quote to value the order at", market.Instrument, direction)
+        }
+        if qty, err = g.clip(market, direction, qty, limits.MaxNotional/ref, "notional limit"); err != nil {
+            return 0, err
+        }
+    }
+    if limits.MaxPosition > 0 {
+        room := limits.MaxPosition - exposure(market, direction)
+        if direction != api.Buy {
+            room = limits.MaxPosition + exposure(market, direction)
+        }
+        if qty, err = g.clip(market, direction, qty, room, "position limit"); err != nil {
+            return 0, err
+        }
+    }
+    return qty, nil
+}
+
+func (g *risk_gateway) Place(market *Market, direction api.Direction, qty int, price int, orderType api.OrderType) (api.Order, error) {
+    qty, err := g.check(market, direction, qty, price, orderType)
+    if err != nil {
+        return api.Order{}, err
+    }
+    return g.next.Place(market, direction, qty, price, orderType)
+}
+
+func (g *risk_gateway) Cancel(order api.Order) error {
+    return g.next.Cancel(order)
+}
+
+// Amend checks the replacement against the limits once the order it
+// replaces is closed, so its shares are not counted twice.
+func (g *risk_gateway) Amend(market *Market, order api.Order, qty int, price int) (api.Order, error) {
+    return amend_order(g, market, order, qty, price)
+}
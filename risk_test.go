@@ -0,0 +1,84 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/mock"
+)
+
+// at values the primary market's position at a balance of nav, at now.
+func at(now time.Time, nav int) {
+    session.Primary().Position.Balance = nav
+    value_positions(now)
+}
+
+func TestDailyLossSurvivesRestart(t *testing.T) {
+    in_store(t, "ME")
+    session = Session{Account: "ME", Instruments: []Instrument{{"TESTEX", "FOO"}}, Mark: MARK_LAST}
+    if err := init_session(); err != nil {
+        t.Fatal(err)
+    }
+    now := time.Date(2016, 1, 1, 9, 0, 0, 0, time.UTC)
+    clock := func() time.Time { return now }
+
+    at(now, 10000)
+    if err := new_risk_gateway(nil, RiskLimits{}, clock).start_day(now); err != nil {
+        t.Fatal(err)
+    }
+
+    // A loss made before any order, then a restart: the new gateway still
+    // counts from the NAV the day started at.
+    now = now.Add(time.Hour)
+    at(now, 9000)
+    risk := new_risk_gateway(nil, RiskLimits{}, clock)
+    if loss, err := risk.daily_loss(); err != nil || loss != 1000 {
+        t.Fatalf("expected a loss of 1000, got %d (%v)", loss, err)
+    }
+
+    now = now.Add(24 * time.Hour)
+    if loss, err := risk.daily_loss(); err != nil || loss != 0 {
+        t.Fatalf("expected no loss on the next day, got %d (%v)", loss, err)
+    }
+}
+
+// A restarted level counts its trading days from the first again: its
+// first day starts at the NAV it restarts with, not the one it first had.
+func TestDailyLossAfterLevelRestart(t *testing.T) {
+    server := mock.NewServer(mock.NewExchange())
+    defer server.Close()
+    globals.gm = server.GameMaster("ME")
+    defer func() { globals.gm = nil }()
+    level, err := globals.gm.StartLevel("first_steps")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    in_store(t, level.Account)
+    session = Session{Mark: MARK_LAST}
+    apply_level(level)
+    if err := init_session(); err != nil {
+        t.Fatal(err)
+    }
+    now := time.Date(2016, 1, 1, 9, 0, 0, 0, time.UTC)
+    clock := func() time.Time { return now }
+
+    at(now, 10000)
+    if err := new_risk_gateway(nil, RiskLimits{}, clock).start_day(now); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := restart_level(level.InstanceId); err != nil {
+        t.Fatal(err)
+    }
+
+    now = now.Add(time.Minute)
+    at(now, 0)
+    risk := new_risk_gateway(nil, RiskLimits{}, clock)
+    if err := risk.start_day(now); err != nil {
+        t.Fatal(err)
+    }
+    at(now, -500)
+    if loss, err := risk.daily_loss(); err != nil || loss != 500 {
+        t.Fatalf("expected a loss of 500 from the restart, got %d (%v)", loss, err)
+    }
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// Instrument is a stock listed on a given venue. The same symbol may be
+// listed on several venues.
+type Instrument struct {
+    Venue  string
+    Symbol string
+}
+
+func (i Instrument) String() string {
+    return i.Venue + ":" + i.Symbol
+}
+
+// OrderKey identifies an order. Order ids are only unique within a venue.
+type OrderKey struct {
+    Venue string
+    Id    int
+}
+
+// Market is everything the session tracks for one instrument.
+type Market struct {
+    Instrument
+    Quotes   *QuoteHistory
+    Book     *OrderBookHistory
+    Position Position
+    // Latest valuation of Position.
+    Valuation Valuation
+}
+
+type Session struct {
+    Account     string
+    Instruments []Instrument
+    // Subscribe to the venue-wide feeds and trade every symbol listed on
+    // the venues of Instruments.
+    VenueWide bool
+    // Level instance the session trades, 0 when it was not started through
+    // the game master. Instance is its last known status.
+    InstanceId int
+    Instance   api.Instance
+    // Mark the positions are valued at, and age beyond which the quote
+    // they are marked from is stale.
+    Mark       string
+    StaleQuote time.Duration
+    // Where the running commentary of the session goes, orders sent and
+    // positions among others; stdout when nil.
+    Out io.Writer
+
+    Markets map[Instrument]*Market
+    // Latest view of each order of the session, kept by the OMS.
+    Orders map[OrderKey]api.Order
+
+    // Orders tracked by the OMS by client id, and the client id of each
+    // order the venue acknowledged.
+    managed      map[int]*ManagedOrder
+    clientIds    map[OrderKey]int
+    nextClientId int
+
+    // Fills applied to the positions, and whether orders were synced with
+    // the venues once, after which a fill found by sync_orders was missed
+    // by the executions feed.
+    fills  map[FillKey]bool
+    synced bool
+
+    quoteFeeds      []*api.Feed
+    executionsFeeds []*api.Feed
+}
+
+var session Session
+
+// Venues lists the venues of the session's instruments, in order.
+func (s *Session) Venues() []string {
+    var venues []string
+    seen := make(map[string]bool)
+    for _, instrument := range s.Instruments {
+        if !seen[instrument.Venue] {
+            seen[instrument.Venue] = true
+            venues = append(venues, instrument.Venue)
+        }
+    }
+    return venues
+}
+
+// Market returns the market of symbol on venue, or nil when the session
+// does not trade it.
+func (s *Session) Market(venue string, symbol string) *Market {
+    return s.Markets[Instrument{Venue: venue, Symbol: symbol}]
+}
+
+// Primary is the market of the first configured instrument, which single
+// stock strategies trade.
+func (s *Session) Primary() *Market {
+    return s.Markets[s.Instruments[0]]
+}
+
+// Listings returns the markets of symbol across every traded venue.
+func (s *Session) Listings(symbol string) []*Market {
+    var markets []*Market
+    for _, instrument := range s.Instruments {
+        if instrument.Symbol == symbol {
+            markets = append(markets, s.Markets[instrument])
+        }
+    }
+    return markets
+}
+
+func (s *Session) printf(format string, args ...interface{}) {
+    out := s.Out
+    if out == nil {
+        out = os.Stdout
+    }
+    fmt.Fprintf(out, format, args...)
+}
+
+func (s *Session) Order(venue string, id int) (api.Order, bool) {
+    order, ok := s.Orders[OrderKey{Venue: venue, Id: id}]
+    return order, ok
+}
+
+// init_session completes the instrument list with every symbol of the
+// venues when trading venue-wide, and creates a market for each.
+func init_session() error {
+    if session.VenueWide {
+        for _, venue := range session.Venues() {
+            symbols, err := globals.client.Stocks(venue)
+            if err != nil {
+                return fmt.Errorf("list stocks on %s: %v", venue, err)
+            }
+            for _, symbol := range symbols {
+                instrument := Instrument{Venue: venue, Symbol: symbol.Symbol}
+                if !session.trades(instrument) {
+                    session.Instruments = append(session.Instruments, instrument)
+                }
+            }
+        }
+    }
+
+    session.Markets = make(map[Instrument]*Market)
+    session.Orders = make(map[OrderKey]api.Order)
+    session.managed = make(map[int]*ManagedOrder)
+    session.clientIds = make(map[OrderKey]int)
+    session.nextClientId = 0
+    session.fills = make(map[FillKey]bool)
+    session.synced = false
+    for _, instrument := range session.Instruments {
+        session.Markets[instrument] = &Market{
+            Instrument: instrument,
+            Quotes:     &QuoteHistory{},
+            Book:       &OrderBookHistory{},
+            Position:   Position{Stock: instrument.Symbol},
+        }
+    }
+    return nil
+}
+
+func (s *Session) trades(instrument Instrument) bool {
+    for _, traded := range s.Instruments {
+        if traded == instrument {
+            return true
+        }
+    }
+    return false
+}
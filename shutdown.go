@@ -0,0 +1,172 @@
+package main
+
+import (
+    "database/sql"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// Attempts at closing every open order before shutdown gives up.
+const CANCEL_ROUNDS = 3
+
+// cancel_all_orders cancels every open order of the session in parallel,
+// then confirms each one is closed with check_order_status. Orders still
+// open are cancelled again, up to CANCEL_ROUNDS times; the error lists
+// those that never closed.
+func cancel_all_orders() error {
+    var open []api.Order
+    for round := 1; round <= CANCEL_ROUNDS; round++ {
+        open = open[:0]
+        for _, order := range session.Orders {
+            if order.Open {
+                open = append(open, order)
+            }
+        }
+        if len(open) == 0 {
+            return nil
+        }
+        log.Printf("Cancelling %d open orders (round %d)", len(open), round)
+
+        // The session is only touched from here: the goroutines make the
+        // requests and hand back the orders whose cancel failed.
+        failed := make(chan api.Order, len(open))
+        for _, order := range open {
+            oms_cancelling(order.Venue, order.Id)
+            go func(order api.Order) {
+                if _, err := globals.client.CancelOrder(order.Venue, order.Symbol, order.Id); err != nil {
+                    log.Printf("Cancel order %d on %s: %s", order.Id, order.Venue, err)
+                    failed <- order
+                    return
+                }
+                failed <- api.Order{}
+            }(order)
+        }
+        for range open {
+            if order := <-failed; order.Id != 0 {
+                oms_cancel_failed(order.Venue, order.Id)
+            }
+        }
+
+        // A cancel the venue turned down may still have closed the order,
+        // its status is what counts.
+        for _, order := range open {
+            if err := check_order_status(order.Id, order.Venue, order.Symbol); err != nil {
+                log.Printf("Confirm cancel of order %d on %s: %s", order.Id, order.Venue, err)
+            }
+        }
+    }
+
+    var unconfirmed []string
+    for _, order := range open {
+        if current, _ := session.Order(order.Venue, order.Id); current.Open {
+            unconfirmed = append(unconfirmed, fmt.Sprintf("%s:%d", order.Venue, order.Id))
+        }
+    }
+    if len(unconfirmed) > 0 {
+        return fmt.Errorf("orders still open after %d rounds of cancels: %v", CANCEL_ROUNDS, unconfirmed)
+    }
+    return nil
+}
+
+// flatten_positions closes the position of every market with a market
+// order. They go straight to the venue: risk limits, the daily loss one
+// above all, must not keep a position open once trading stops. Whatever
+// the book could not absorb is left open and reported.
+func flatten_positions() error {
+    for _, instrument := range session.Instruments {
+        market := session.Markets[instrument]
+        owned := market.Position.Owned
+        if owned == 0 {
+            continue
+        }
+        direction := api.Sell
+        if owned < 0 {
+            direction = api.Buy
+        }
+        log.Printf("Flattening %s: %s %d shares", instrument, direction, abs(owned))
+        order, err := place_order(api.OrderRequest{
+            Account:   session.Account,
+            Venue:     instrument.Venue,
+            Stock:     instrument.Symbol,
+            Qty:       abs(owned),
+            Direction: direction,
+            OrderType: api.Market,
+        })
+        if err != nil {
+            return fmt.Errorf("flatten %s: %v", instrument, err)
+        }
+        if err := check_order_status(order.Id, order.Venue, order.Symbol); err != nil {
+            return fmt.Errorf("flatten %s: %v", instrument, err)
+        }
+    }
+
+    var open []string
+    for _, instrument := range session.Instruments {
+        if owned := session.Markets[instrument].Position.Owned; owned != 0 {
+            open = append(open, fmt.Sprintf("%s %d", instrument, owned))
+        }
+    }
+    if len(open) > 0 {
+        return fmt.Errorf("positions left open, the book was too thin: %v", open)
+    }
+    return nil
+}
+
+// shutdown leaves the venue clean once the strategy stopped: no order
+// resting, the inventory sold back when flatten is set, and the final
+// orders and positions recorded. Every step runs even if one before
+// failed; the first failure is returned.
+func shutdown(now time.Time, flatten bool) error {
+    if session.Markets == nil {
+        // The session never started, nothing was traded.
+        return nil
+    }
+    var firstErr error
+    fail := func(err error) {
+        if err == nil {
+            return
+        }
+        log.Printf("Shutdown: %s", err)
+        if firstErr == nil {
+            firstErr = err
+        }
+    }
+
+    fail(cancel_all_orders())
+    if flatten {
+        fail(flatten_positions())
+        // A market order the book could not fill is closed by the venue,
+        // but make sure nothing rests.
+        fail(cancel_all_orders())
+    }
+    value_positions(now)
+    show_position()
+    fail(record_positions(now))
+    return firstErr
+}
+
+// engage_kill_switch stops every bot trading the account, now and until
+// the switch is released.
+func engage_kill_switch(now time.Time, reason string) error {
+    return in_transaction(func(tx *sql.Tx) error {
+        return globals.store.SetKillSwitch(tx, now, session.Account, true, reason)
+    })
+}
+
+func release_kill_switch(now time.Time) error {
+    return in_transaction(func(tx *sql.Tx) error {
+        return globals.store.SetKillSwitch(tx, now, session.Account, false, "")
+    })
+}
+
+// kill_switch tells whether the kill switch of the account is engaged, and
+// why. Without a store it never is.
+func kill_switch() (bool, string, error) {
+    if globals.store == nil {
+        return false, "", nil
+    }
+    return globals.store.KillSwitch(session.Account)
+}
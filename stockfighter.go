@@ -1,19 +1,18 @@
 package main
 
 import (
-    "bytes"
-    "encoding/json"
     "database/sql"
     _ "github.com/mattn/go-sqlite3"
     "fmt"
-    "io/ioutil"
     "log"
-    "net/http"
-    "golang.org/x/net/websocket"
+    "os"
     "reflect"
     "runtime"
+    "sort"
+    "sync"
     "time"
-    "math"
+
+    "github.com/vincenzoauteri/stockfighter/api"
 )
 
 func btoi(b bool) int {
@@ -31,52 +30,17 @@ var profiling struct {
 }
 
 var globals struct {
-    ApiKey string
-    httpClient http.Client
-    wsExecutions *websocket.Conn
-    wsQuote *websocket.Conn
-}
-
-type StockQuote struct {
-    Ok bool  `json:"ok"`
-    Symbol string `json:"symbol"`
-    Venue string  `json:"venue"`
-    Bid int  `json:"bid"`
-    Ask int `json:"ask"`
-    BidSize int  `json:"bidSize"`
-    AskSize int `json:"askSize"`
-    BidDepth int  `json:"bidDepth"`
-    AskDepth int `json:"askDepth"`
-    Last int `json:"last"`
-    LastSize int `json:"lastSize"`
-    LastTrade string  `json:"lastTrade"`
-    QuoteTime string `json:"quoteTime"`
-}
-
-type StockQuoteWs struct {
-    Ok bool  `json:"ok"`
-    Quote struct {
-        Symbol string `json:"symbol"`
-        Venue string  `json:"venue"`
-        Bid int  `json:"bid"`
-        Ask int `json:"ask"`
-        BidSize int  `json:"bidSize"`
-        AskSize int `json:"askSize"`
-        BidDepth int  `json:"bidDepth"`
-        AskDepth int `json:"askDepth"`
-        Last int `json:"last"`
-        LastSize int `json:"lastSize"`
-        LastTrade string  `json:"lastTrade"`
-        QuoteTime string `json:"quoteTime"`
-    } `json:quote`
+    client *api.Client
+    gm     *api.GameMaster
+    store  *Repository
 }
 
-var stockQuote StockQuote
-var stockQuoteWs StockQuoteWs
-
-var quoteHistory struct {
+type QuoteHistory struct {
     ready bool
-    history []StockQuoteWs
+    history []api.StockQuoteWs
+    last api.Quote
+    // When last was received, by our clock.
+    received time.Time
 
     lastTopBidPrice int
     lastTopAskPrice int
@@ -94,33 +58,12 @@ var quoteHistory struct {
 
     lastBidPrice int
     lastAskPrice int
-
-    lastBidId int
-    lastAskId int
-}
-
-type OrderBook struct {
-    Ok     bool   `json:"ok"`
-    Venue  string `json:"venue"`
-    Symbol string `json:"symbol"`
-    Bids   []struct {
-        Price int  `json:"price"`
-        Qty   int  `json:"qty"`
-        IsBuy bool `json:"isBuy"`
-    } `json:"bids"`
-    Asks []struct {
-        Price int  `json:"price"`
-        Qty   int  `json:"qty"`
-        IsBuy bool `json:"isBuy"`
-    } `json:"asks"`
-    Ts string `json:ts`
 }
 
-var orderBook OrderBook
-
-var orderBookHistory struct {
+type OrderBookHistory struct {
     ready bool
-    history []OrderBook
+    history []api.OrderBook
+    last api.OrderBook
     lastTopBidPrice int
     lastTopAskPrice int
     avgTopBidQty float64
@@ -144,239 +87,36 @@ type Position struct {
     Owned int
     Balance int
     NAV int
+    // Average price of the shares held, long or short.
+    AvgCost float64
+    Realised int
+    Unrealised int
 }
 
-var data struct {
-    Id string
-    Venue string
-    Stocks []string
-    Orders map[int]Order
-    Positions map[string]Position
-}
-
-type AllOrders struct {
-    Ok     bool     `json:"ok"`
-    Venue  string   `json:"venue"`
-    Orders []Order  `json:"orders"`
-}
-
-
-type Order struct {
-    Ok          bool   `json:"ok"`
-    Symbol      string `json:"symbol"`
-    Venue       string `json:"venue"`
-    Direction   string `json:"direction"`
-    OriginalQty int    `json:"originalQty"`
-    Qty         int    `json:"qty"`
-    Price       int    `json:"price"`
-    OrderType   string `json:"orderType"`
-    Id          int    `json:"id"`
-    Account     string `json:"account"`
-    Ts          string `json:ts`
-
-    Fills []struct {
-        Price int    `json:"price"`
-        Qty   int    `json:"qty"`
-        Ts    string `json:ts`
-    } `json:"fills"`
-    TotalFilled int  `json:"totalFilled"`
-    Open        bool `json:"open"`
-}
-
-type Executions struct {
-    Ok          bool   `json:"ok"`
-    Account     string `json:"account"`
-    Venue       string `json:"venue"`
-    Symbol      string `json:"symbol"`
-    Order Order `json:"order"`
-    StandingId int `json:"standingId"`
-    IncomingId int `json:"incomingId"`
-    Price       int    `json:"price"`
-    Filled int  `json:"filled"`
-    FilledAt string `json:"filledAt"`
-    StandingComplete bool `json:"standingComplete"`
-    IncomingComplete bool `json:"incomingComplete"`
-}
-
-var executions Executions
-
 func GetFunctionName(i interface{}) string {
     return runtime.FuncForPC(reflect.ValueOf(i).Pointer()).Name()
 }
 
-func initDb(account string) *sql.DB {
+func initDb(account string) (*sql.DB, error) {
 
     db, err := sql.Open("sqlite3", fmt.Sprintf("./%s.db",account))
 
     if err != nil {
-        log.Fatal(err)
-    }
-
-    sqlStmt := `SELECT name FROM sqlite_master WHERE type='table' AND name='position';`
-
-    var name string
-    err = db.QueryRow(sqlStmt).Scan(&name);
-
-    if err == sql.ErrNoRows {
-        sqlStmt = `
-        CREATE table position (stock string not null primary key, owned integer , balance integer) ;
-        CREATE table orders (id number not null primary key, stock string, direction string, type string, price number, qty number, filled number, open integer);
-        `;
-        _ , err = db.Exec(sqlStmt);
-
-        if err != nil {
-            log.Fatal(err)
-        } else {
-            fmt.Printf("Database :%s.db created\n",account);
-        }
-    } else if err != nil {
-        log.Print(err)
-    } else {
-        fmt.Printf("Database :%s.db exists\n",account);
-    }
-
-    //defer db.Close()
-    return db
-}
-
-func heartbeat() bool {
-    httpRequest, err := http.NewRequest("GET", "https://api.stockfighter.io/ob/api/heartbeat", nil)
-    httpResponse, err := globals.httpClient.Do(httpRequest)
-
-    if err != nil {
-        log.Fatal(err)
-    }
-
-    responseData, err := ioutil.ReadAll(httpResponse.Body)
-    httpResponse.Body.Close()
-
-    type heartbeatResponse struct {
-        Ok    bool   `json:"ok"`
-        Error string `json:"error"`
-    }
-
-    var tempJson heartbeatResponse
-
-    err = json.Unmarshal([]byte(responseData), &tempJson)
-
-    if err != nil {
-        log.Fatal(err)
-    }
-    //fmt.Printf("%+v\n", tempJson)
-    return tempJson.Ok
-}
-
-func check_venue_inteface(venue string) bool {
-
-    requestUrl := fmt.Sprintf("https://api.stockfighter.io/ob/api/venues/%s/heartbeat", venue)
-    httpRequest, err := http.NewRequest("GET", requestUrl, nil)
-    httpResponse, err := globals.httpClient.Do(httpRequest)
-
-    if err != nil {
-        log.Fatal(err)
-    }
-
-    responseData, err := ioutil.ReadAll(httpResponse.Body)
-    httpResponse.Body.Close()
-
-    jsonDecoder := json.NewDecoder(bytes.NewReader(responseData))
-
-    var tempJson interface{}
-
-    err = jsonDecoder.Decode(&tempJson)
-
-    m := tempJson.(map[string]interface{})
-
-    if err != nil {
-        log.Fatal(err)
-    }
-    fmt.Printf("%d\n", m["ok"])
-    return false
-}
-
-func check_venue(venue string) bool {
-
-    requestUrl := fmt.Sprintf("https://api.stockfighter.io/ob/api/venues/%s/heartbeat", venue)
-    httpRequest, err := http.NewRequest("GET", requestUrl, nil)
-    httpResponse, err := globals.httpClient.Do(httpRequest)
-
-    if err != nil {
-        log.Fatal(err)
+        return nil, err
     }
 
-    responseData, err := ioutil.ReadAll(httpResponse.Body)
-
-    type venueResponse struct {
-        Ok    bool   `json:"ok"`
-        Venue string `json:"venue"`
-    }
-    var tempJson venueResponse
-
-    err = json.Unmarshal([]byte(responseData), &tempJson)
+    version, err := migrate(db)
 
     if err != nil {
-        log.Fatal(err)
+        db.Close()
+        return nil, err
     }
-    //fmt.Printf("%+v\n", tempJson)
-    return tempJson.Ok
-}
-
-func quote_stock(venue string, stock string) bool {
-    requestUrl := fmt.Sprintf("https://api.stockfighter.io/ob/api/venues/%s/stocks/%s/quote", venue, stock)
-    httpRequest, err := http.NewRequest("GET", requestUrl, nil)
-    httpResponse, err := globals.httpClient.Do(httpRequest)
+    fmt.Printf("Database :%s.db at schema version %d\n",account, version);
 
-    if err != nil {
-        log.Fatal(err)
-    }
-
-    responseData, err := ioutil.ReadAll(httpResponse.Body)
-
-
-    err = json.Unmarshal([]byte(responseData), &stockQuote)
-
-    fmt.Printf("Last price: %2f\n",float32(stockQuote.Last) )
-
-    if err != nil {
-        log.Fatal(err)
-    }
-    return stockQuote.Ok
-}
-
-func check_stocks(venue string) bool {
-
-    requestUrl := fmt.Sprintf("https://api.stockfighter.io/ob/api/venues/%s/stocks", venue)
-    httpRequest, err := http.NewRequest("GET", requestUrl, nil)
-    httpResponse, err := globals.httpClient.Do(httpRequest)
-
-    if err != nil {
-        log.Fatal(err)
-    }
-
-    responseData, err := ioutil.ReadAll(httpResponse.Body)
-    //fmt.Printf("%s\n", responseData)
-
-    type stockResponse struct {
-        Ok      bool `json:"ok"`
-        Symbols []struct {
-            Name   string `json:"name"`
-            Symbol string `json:"symbol"`
-        } `json:"symbols"`
-    }
-    var tempJson stockResponse
-
-    err = json.Unmarshal([]byte(responseData), &tempJson)
-
-    if err != nil {
-        log.Fatal(err)
-    }
-    //fmt.Printf("%+v\n", tempJson)
-    return tempJson.Ok
+    return db, nil
 }
 
-
-func update_quotes() {
-    ts := time.Now()
+func update_quote_history(quoteHistory *QuoteHistory) {
     MOVING_AVERAGE := 1000
     quoteHistory.avgTopBidQty = 0;
     quoteHistory.avgTopBidPrice= 0;
@@ -387,7 +127,6 @@ func update_quotes() {
     quoteHistory.minTopAskPrice= 100000;
     quoteHistory.maxTopAskPrice= 0;
     historyLength := len(quoteHistory.history)
-    fmt.Printf("History Length: %d\n\n", historyLength)
     if historyLength > MOVING_AVERAGE {
         quoteHistory.history = quoteHistory.history[historyLength - MOVING_AVERAGE -1 : historyLength -1]
         quoteHistory.ready = true
@@ -428,31 +167,23 @@ func update_quotes() {
             quoteHistory.avgTopAskPrice /= askAvg;
         }
     }
-    profiling.Samples += 1
-    profiling.Executions += 1
-    te:= time.Now()
-    duration := te.Sub(ts)
-    profiling.ExecutionTime = duration
 }
-func update_order_book(venue string, stock string) (bool) {
-
-    requestUrl := fmt.Sprintf("https://api.stockfighter.io/ob/api/venues/%s/stocks/%s", venue, stock)
-    httpRequest, err := http.NewRequest("GET", requestUrl, nil)
-    httpResponse, err := globals.httpClient.Do(httpRequest)
+func update_order_book(market *Market) error {
 
-    if err != nil {
-        log.Fatal(err)
-    }
+    book, err := globals.client.OrderBook(market.Venue, market.Symbol)
 
-    responseData, err := ioutil.ReadAll(httpResponse.Body)
     if err != nil {
-        log.Fatal(err)
+        return fmt.Errorf("update order book: %v", err)
     }
 
-    err = json.Unmarshal([]byte(responseData), &orderBook)
+    update_order_book_history(market.Book, book)
+    return nil
+}
 
+func update_order_book_history(orderBookHistory *OrderBookHistory, book api.OrderBook) {
+    orderBookHistory.last = book
 
-    orderBookHistory.history = append(orderBookHistory.history, orderBook);
+    orderBookHistory.history = append(orderBookHistory.history, book);
 
 
     MOVING_AVERAGE := 10000
@@ -508,545 +239,264 @@ func update_order_book(venue string, stock string) (bool) {
     }
 
     //fmt.Printf("AvgTopAskPrice: %f AvgTopBidPrice :%f \n\n", orderBookHistory.avgTopAskPrice, orderBookHistory.avgTopBidPrice)
-
-
-    if err != nil {
-        log.Fatal(err)
-    }
-
-    if !orderBook.Ok {
-        fmt.Printf("Update Order Book: %s\n\n", responseData)
-    }
-
-    return orderBook.Ok
 }
 
+func cancel_order(venue string, stock string, id int) error {
 
-func cancel_order(venue string, stock string, id int) bool {
-
-    requestUrl := fmt.Sprintf("https://api.stockfighter.io/ob/api/venues/%s/stocks/%s/orders/%d", venue, stock, id)
-
-    httpRequest, err := http.NewRequest("DELETE", requestUrl, nil)
-    if err != nil {
-        log.Fatal(err)
-    }
-    httpRequest.Header.Add("X-Starfighter-Authorization", globals.ApiKey)
-    httpResponse, err := globals.httpClient.Do(httpRequest)
-
-    if err != nil {
-        log.Fatal(err)
-    }
-
-    responseData, err := ioutil.ReadAll(httpResponse.Body)
-    //fmt.Printf("%s\n", responseData)
-
-    var tempJson Order
-
-    err = json.Unmarshal([]byte(responseData), &tempJson)
+    oms_cancelling(venue, id)
+    _, err := globals.client.CancelOrder(venue, stock, id)
 
     if err != nil {
-        log.Fatal(err)
+        oms_cancel_failed(venue, id)
+        return err
     }
 
-    if (tempJson.Ok) {
-        check_order_status(id, venue , stock)
-    }
-
-
-    return tempJson.Ok
+    return check_order_status(id, venue , stock)
 }
 
-func place_order(venue string, stock string, direction string, account string, qty int, price int, orderType string) (bool, int, int) {
+// place_order sends request to the venue. Orders other than limit ones
+// come back closed, with whatever they filled.
+func place_order(request api.OrderRequest) (api.Order, error) {
 
-
-    requestUrl := fmt.Sprintf("https://api.stockfighter.io/ob/api/venues/%s/stocks/%s/orders", venue, stock)
-
-    //POST data here
-    var jsonStr = []byte(fmt.Sprintf(" { \"venue\":\"%s\",\"stock\":\"%s\",\"account\": \"%s\",\"price\":%d, \"qty\":%d,\"direction\":\"%s\", \"ordertype\":\"%s\" }", venue, stock, account, price, qty, direction, orderType))
-
-    httpRequest, err := http.NewRequest("POST", requestUrl, bytes.NewBuffer(jsonStr))
-    httpRequest.Header.Add("X-Starfighter-Authorization", globals.ApiKey)
-    httpResponse, err := globals.httpClient.Do(httpRequest)
+    managed := oms_new(request.Order())
+    order, err := globals.client.PlaceOrder(request)
 
     if err != nil {
-        log.Fatal(err)
+        // Should the order have reached the venue after all, the next sync
+        // adopts it.
+        oms_rejected(managed, err)
+        return order, err
     }
 
-    responseData, err := ioutil.ReadAll(httpResponse.Body)
+    oms_acknowledged(managed, order)
+    fills := update_order(order)
 
-    var tempJson Order
-
-    err = json.Unmarshal([]byte(responseData), &tempJson)
-
-    if err != nil {
-        log.Fatal(err)
+    if err := record_order(order, fills); err != nil {
+        return order, err
     }
-
-    if tempJson.Ok {
-        update_order_and_position(&tempJson,nil);
-
-    } else {
-        fmt.Printf("%s\n", responseData)
-    }
-
-    /*
-    if tempJson.Ok {
-        sqlStmt := fmt.Sprintf(`INSERT INTO orders 
-        (id, stock, direction, type, price, qty, filled, open ) 
-        VALUES 
-        (%d, "%s", "%s", "%s", %d, %d, %d, %d);`,
-        tempJson.Id,
-        tempJson.Symbol,tempJson.Direction,tempJson.OrderType,
-        tempJson.Price,tempJson.Qty,tempJson.TotalFilled, btoi(tempJson.Open));
-
-        _ , err = db.Exec (sqlStmt);
-
-        if err != nil {
-            log.Fatal(err)
-
-        }
-    }
-    */
-    return tempJson.Ok,tempJson.Id, tempJson.TotalFilled
+    return order, nil
 }
 
 func show_position(){
-    pos := data.Positions[data.Stocks[0]]
-    fmt.Printf("\nCash :%.2f Owned:%d NAV:%.2f\n", float64(pos.Balance)/100.0, pos.Owned, float64(pos.NAV)/100.0)
-
+    session.printf("\n")
+    for _, instrument := range session.Instruments {
+        market := session.Markets[instrument]
+        pos := market.Position
+        session.printf("%s Cash :%.2f Owned:%d AvgCost:%.2f NAV:%.2f Realised:%.2f Unrealised:%.2f\n", instrument,
+            float64(pos.Balance)/100.0, pos.Owned, pos.AvgCost/100.0, float64(pos.NAV)/100.0,
+            float64(pos.Realised)/100.0, float64(pos.Unrealised)/100.0)
+        if valuation := market.Valuation; !valuation.Time.IsZero() {
+            stale := ""
+            if valuation.Stale {
+                stale = " STALE"
+            }
+            session.printf("%s Marked at %.2f (%s) quote age %s%s\n", instrument,
+                float64(valuation.Price)/100.0, valuation.Mark, valuation.QuoteAge, stale)
+        }
+    }
 }
 
-func update_position_sql(stock string, change int, price int, db *sql.DB){
-
-    type Position struct {
-        Stock string
-        Owned int
-        Balance int
+// show_rate_limits prints how long requests queued for their turn, per
+// endpoint.
+func show_rate_limits() {
+    if globals.client == nil || globals.client.Limiter == nil {
+        return
     }
-
-    var pos Position
-
-    sqlStmt := fmt.Sprintf(`SELECT * FROM position WHERE stock="%s";`,stock);
-
-    err := db.QueryRow(sqlStmt).Scan(&pos.Stock,&pos.Owned,&pos.Balance)
-
-    if err==sql.ErrNoRows{
-        sqlStmt := fmt.Sprintf(`INSERT INTO position
-        (stock, owned, balance) 
-        VALUES 
-        ("%s", %d, %d);`,stock,0,0);
-        _ , err = db.Exec(sqlStmt);
+    stats := globals.client.Limiter.Stats()
+    endpoints := make([]string, 0, len(stats))
+    for endpoint := range stats {
+        endpoints = append(endpoints, string(endpoint))
     }
-
-    if err!=nil {
-        log.Fatal(err)
-    }
-
-    sqlStmt = fmt.Sprintf(`UPDATE position SET owned=%d, balance=%d  WHERE stock="%s";`,
-    pos.Owned+change, pos.Balance+price, pos.Stock);
-    _ , err = db.Exec(sqlStmt);
-
-    if err!=nil {
-        log.Fatal(err)
+    sort.Strings(endpoints)
+    for _, endpoint := range endpoints {
+        s := stats[api.Endpoint(endpoint)]
+        session.printf("Requests %s: %d sent, %d queued, wait avg %s max %s, %d waiting (max %d)\n", endpoint,
+            s.Requests, s.Queued, s.AverageWait(), s.MaxWait, s.Waiting, s.MaxWaiting)
     }
 }
 
-func get_all_orders(account string, venue string, stock string) bool {
+func get_all_orders(account string, venue string, stock string) error {
 
-    requestUrl := fmt.Sprintf("https://api.stockfighter.io/ob/api/venues/%s/accounts/%s/stocks/%s/orders", venue, account, stock)
-
-    httpRequest, err := http.NewRequest("GET", requestUrl, nil)
-    httpRequest.Header.Add("X-Starfighter-Authorization", globals.ApiKey)
-    httpResponse, err := globals.httpClient.Do(httpRequest)
+    orders, err := globals.client.AllOrders(account, venue, stock)
 
     if err != nil {
-        fmt.Printf("%s\n", httpResponse)
-        log.Fatal(err)
+        return fmt.Errorf("get all orders: %v", err)
+    }
+
+    for _, order := range orders {
+        saved, known := session.Order(order.Venue, order.Id)
+        fills := update_order(order)
+        report_missed_fills(order, fills)
+        if !known || len(fills) > 0 || saved.TotalFilled != order.TotalFilled || saved.Open != order.Open {
+            if err := record_order(order, fills); err != nil {
+                return err
+            }
+        }
     }
 
-    responseData, err := ioutil.ReadAll(httpResponse.Body)
+    return nil
+}
 
-    var tempJson AllOrders
+func check_order_status(id int, venue string, stock string) error {
 
-    err = json.Unmarshal([]byte(responseData), &tempJson)
+    order, err := globals.client.OrderStatus(venue, stock, id)
 
     if err != nil {
-        fmt.Printf("%s\n", responseData)
-        log.Fatal(err)
+        return err
     }
 
-    if tempJson.Ok {
-        for _, order := range tempJson.Orders {
-            update_order_and_position(&order,nil)
-        }
+    var fills []int
+    if _, ok := session.Order(venue, id); ok {
+        fills = update_order(order)
     }
 
-    return tempJson.Ok
-
+    return record_order(order, fills)
 }
 
-func update_position(stock string , cashDiff int, qtyDiff int)  {
-    owned:=0
-    balance:=0
-    if  savedPosition, ok := data.Positions[stock]; ok  {
-        owned = savedPosition.Owned
-        balance = savedPosition.Balance
-    }
-
-    newPosition := Position {
-        Stock       :stock,
-        Owned       :owned   + qtyDiff ,
-        Balance     :balance + cashDiff,
-        NAV         :balance + cashDiff + owned * stockQuoteWs.Quote.Last,
-    }
-    data.Positions[stock] = newPosition;
-    //fmt.Printf("New Position %v\n", newPosition)
-}
 
-func update_order_and_position(newOrder *Order, oldOrder *Order)  {
-    cashDiff:=0
-    qtyDiff:=0
-    if oldOrder ==  nil {
-        for _ , fill := range newOrder.Fills {
-            //t,_ := time.Parse(time.RFC3339Nano ,fill.Ts)
-            //fmt.Printf("Adding fill to Order %d price %d qty %d, Timestamp %s \n", newOrder.Id,fill.Price,fill.Qty,t )
-            cashDiff+=(fill.Price*fill.Qty)
-            qtyDiff+=fill.Qty
-        }
-    } else  {
-        tOld, _ := time.Parse(time.RFC3339Nano ,oldOrder.Ts)
-        for _ , fill := range newOrder.Fills {
-            tNew, _ :=  time.Parse(time.RFC3339Nano ,fill.Ts)
-            if (tNew.After(tOld)) {
-                fmt.Printf("Id %d price %d qty %d, Ts %s newer than %s\n", newOrder.Id,fill.Price,fill.Qty, tNew, tOld)
-                cashDiff+=(fill.Price*fill.Qty)
-                qtyDiff+=fill.Qty
-            } else {
-                //fmt.Printf("Id %d Price %d Qty %d,Ts %s older than %s\n", newOrder.Id,fill.Price,fill.Qty, tNew, tOld)
-            }
-        }
+// subscriptions lists what the feeds and order queries are scoped to: one
+// entry per instrument, or per venue with an empty symbol when venue-wide.
+func subscriptions() []Instrument {
+    if !session.VenueWide {
+        return session.Instruments
     }
-    if newOrder.Direction == "buy" {
-        update_position(newOrder.Symbol, -cashDiff, qtyDiff)
-    } else {
-        update_position(newOrder.Symbol, cashDiff, -qtyDiff)
+    var venues []Instrument
+    for _, venue := range session.Venues() {
+        venues = append(venues, Instrument{Venue: venue})
     }
-    //fmt.Printf("Order %d Details: %v\n", newOrder.Id, *newOrder)
-    data.Orders[newOrder.Id] = *newOrder;
+    return venues
 }
 
-func update_executions_and_position()  {
-    cashDiff:=0
-    qtyDiff:=0
-    order := executions.Order
-    oldOrder , ok := data.Orders[order.Id]
-    if !ok {
-        for _ , fill := range order.Fills {
-            //t,_ := time.Parse(time.RFC3339Nano ,fill.Ts)
-            //fmt.Printf("Adding fill to Order %d price %d qty %d, Timestamp %s \n", order.Id,fill.Price,fill.Qty,t )
-            cashDiff+=(fill.Price*fill.Qty)
-            qtyDiff+=fill.Qty
-        }
-    } else  {
-        tOld, _ := time.Parse(time.RFC3339Nano ,oldOrder.Ts)
-        for _ , fill := range order.Fills {
-            tNew, _ :=  time.Parse(time.RFC3339Nano ,fill.Ts)
-            if (tNew.After(tOld)) {
-                fmt.Printf("Id %d price %d qty %d, Ts %s newer than %s\n", order.Id,fill.Price,fill.Qty, tNew, tOld)
-                cashDiff+=(fill.Price*fill.Qty)
-                qtyDiff+=fill.Qty
-            } else {
-                //fmt.Printf("Id %d Price %d Qty %d,Ts %s older than %s\n", order.Id,fill.Price,fill.Qty, tNew, tOld)
-            }
+func sync_orders() error {
+    for _, subscription := range subscriptions() {
+        if err := get_all_orders(session.Account, subscription.Venue, subscription.Symbol); err != nil {
+            return err
         }
     }
-    if order.Direction == "buy" {
-        //update_position(order.Symbol, -cashDiff, qtyDiff)
-    } else {
-        //update_position(order.Symbol, cashDiff, -qtyDiff)
-    }
-    //fmt.Printf("Order %d Details: %v\n", newOrder.Id, *order)
-    data.Orders[order.Id] = order;
+    session.synced = true
+    return nil
 }
 
-func check_order_status(id int, venue string, stock string) bool {
-
-    requestUrl := fmt.Sprintf("https://api.stockfighter.io/ob/api/venues/%s/stocks/%s/orders/%d", venue, stock, id)
-
-    httpRequest, err := http.NewRequest("GET", requestUrl, nil)
-    httpRequest.Header.Add("X-Starfighter-Authorization", globals.ApiKey)
-    httpResponse, err := globals.httpClient.Do(httpRequest)
-
-    if err != nil {
-        log.Fatal(err)
-    }
-
-    responseData, err := ioutil.ReadAll(httpResponse.Body)
-    //fmt.Printf("%s\n", responseData)
-
-    var tempJson Order
-
-    err = json.Unmarshal([]byte(responseData), &tempJson)
-
-    if err != nil {
-        log.Fatal(err)
-    }
-
-    if tempJson.Ok {
+func init_web_sockets() error {
+    for _, subscription := range subscriptions() {
+        quoteFeed := globals.client.TickertapeFeed(session.Account, subscription.Venue, subscription.Symbol)
+        if err := quoteFeed.Connect(); err != nil {
+            close_web_sockets()
+            return fmt.Errorf("%s: %v", quoteFeed.Name, err)
+        }
+        session.quoteFeeds = append(session.quoteFeeds, quoteFeed)
 
-        if  savedOrder, ok :=  data.Orders[id]; ok {
-            update_order_and_position(&tempJson, &savedOrder)
+        executionsFeed := globals.client.ExecutionsFeed(session.Account, subscription.Venue, subscription.Symbol)
+        if err := executionsFeed.Connect(); err != nil {
+            close_web_sockets()
+            return fmt.Errorf("%s: %v", executionsFeed.Name, err)
         }
-    }else {
-        fmt.Printf("Response NOK %s\n",responseData)
+        session.executionsFeeds = append(session.executionsFeeds, executionsFeed)
     }
-
-    return tempJson.Ok
+    return nil
 }
 
-
-func cancel_all_orders() {
-    for id, order:= range data.Orders {
-        if (order.Open) {
-            cancel_order(data.Venue, order.Symbol, id)
-        }
+func close_web_sockets() {
+    for _, feed := range append(session.quoteFeeds, session.executionsFeeds...) {
+        feed.Close()
     }
 }
 
-
-
-func execute_strategy (strategy string) {
-    switch strategy {
-    case "buy":
-        {
-            buyQty:= 1000;
-            buyPrice:= int(orderBookHistory.avgTopBidPrice)  ;
-            ok, id, filled := place_order(data.Venue, data.Stocks[0], "buy", data.Id, buyQty, buyPrice, "limit")
-            if (ok) {
-                fmt.Printf("Buy Order sent id:%d price %d filled:%d\n", id, buyPrice, filled)
-
-            }
-
-            sellQty:= 900;
-            sellPrice:= int(orderBookHistory.avgTopAskPrice) ;
-
-            ok, id, filled = place_order(data.Venue, data.Stocks[0], "sell", data.Id, sellQty, sellPrice, "limit")
-            if (ok) {
-                fmt.Printf("Sell Order sent id:%d price %d filled:%d\n", id, sellPrice, filled)
-
-            }
+func feeds_up() bool {
+    for _, feed := range append(session.quoteFeeds, session.executionsFeeds...) {
+        if !feed.Up() {
+            return false
         }
-    case "marketMaker":
-        {
-            spread := quoteHistory.lastTopAskPrice - quoteHistory.lastTopBidPrice
-            buyPrice := quoteHistory.minTopAskPrice
-
-            sellPrice := quoteHistory.maxTopBidPrice
-
-            fmt.Printf("Buyprice :%d SellPrice :%d Last Spread :%d\n", buyPrice, sellPrice, spread)
-
-            buyQty :=  100- data.Positions[data.Stocks[0]].Owned/2
-
-            sellQty := 100+ data.Positions[data.Stocks[0]].Owned/2
-
-            //if ( data.Positions[data.Stocks[0]].Owned < 0) {
-            if (buyPrice < sellPrice) {
-                lastBidOrder := data.Orders[quoteHistory.lastBidId]
-                fmt.Printf("lastBidOrder :%d open :%t \n", lastBidOrder.Id,lastBidOrder.Open)
-                if !lastBidOrder.Open {
-                    if ( buyQty > 0 ){
-                        ok, id, filled := place_order(data.Venue, data.Stocks[0], "buy", data.Id, buyQty, buyPrice, "limit")
-                        if (ok) {
-                            fmt.Printf("Buy Order sent id:%d price %d filled:%d\n", id, buyPrice, filled)
-                            quoteHistory.lastBidId = id;
-                        }
-                    }
-                } else {
-                    if math.Abs(float64(lastBidOrder.Price - buyPrice)) > float64(buyPrice)*0.05 {
-                        ok := cancel_order(data.Venue, lastBidOrder.Symbol, lastBidOrder.Id)
-                        if ok {
-                            fmt.Printf("Canceled Buy Order id:%d \n", lastBidOrder.Id)
-                        }
-                    }
-                }
-                lastAskOrder := data.Orders[quoteHistory.lastAskId]
-                fmt.Printf("lastAskOrder :%d open :%t \n", lastAskOrder.Id,lastAskOrder.Open)
-                if !lastAskOrder.Open {
-                    if ( sellQty > 0){
-                        ok, id, filled := place_order(data.Venue, data.Stocks[0], "sell", data.Id, sellQty, sellPrice, "limit")
-                        if (ok) {
-                            fmt.Printf("Sell Order sent id:%d price %d filled:%d\n", id, sellPrice, filled)
-                            quoteHistory.lastAskId = id;
-                        }
-                    }
-                } else {
-                    if math.Abs(float64(lastAskOrder.Price - buyPrice)) > float64(sellPrice)*0.05{
-                        ok:= cancel_order(data.Venue, lastAskOrder.Symbol, lastAskOrder.Id)
-                        if ok {
-                            fmt.Printf("Canceled Sell Order id:%d \n", lastAskOrder.Id)
-                        }
-                    }
+    }
+    return true
+}
+
+// run_feeds starts feeds and merges their messages into a single channel,
+// closed once every feed has stopped. Messages still pending when stop is
+// closed are dropped.
+func run_feeds(feeds []*api.Feed, stop <-chan struct{}) <-chan interface{} {
+    merged := make(chan interface{})
+    var wg sync.WaitGroup
+    for _, feed := range feeds {
+        messages := make(chan interface{})
+        go feed.Run(messages)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for message := range messages {
+                select {
+                case merged <- message:
+                case <-stop:
                 }
             }
-        }
-    case "level4":
-        {
-            //spread := quoteHistory.lastTopAskPrice - quoteHistory.lastTopBidPrice
-                buyPrice := int(quoteHistory.avgTopBidPrice)
-                sellPrice := int(quoteHistory.avgTopAskPrice)
-            if  data.Positions[data.Stocks[0]].Owned >= -200 {
-                buyPrice = int(quoteHistory.minTopAskPrice)
-            }
-
-            if  data.Positions[data.Stocks[0]].Owned <= 200 {
-                sellPrice = int(quoteHistory.maxTopBidPrice)
-            }
-            fmt.Printf("Buyprice :%d AverageBidPrice :%d SellPrice: %d AverageAskPrice: %d\n", 
-            buyPrice, int(quoteHistory.avgTopBidPrice),sellPrice,int(quoteHistory.avgTopAskPrice));
-
-            //buyQty :=  100- data.Positions[data.Stocks[0]].Owned/2
-
-            //sellQty := 100+ data.Positions[data.Stocks[0]].Owned/2
-
-            buyQty :=  100 ;
+        }()
+    }
+    go func() {
+        wg.Wait()
+        close(merged)
+    }()
+    return merged
+}
 
-                sellQty := 100  ;
+func report_gap(gap api.Gap) {
+    log.Printf("%s was down for %s (%d attempts): %v", gap.Feed, gap.Duration(), gap.Attempts, gap.Err)
+}
 
-            lastAskOrder := data.Orders[quoteHistory.lastAskId]
-            lastBidOrder := data.Orders[quoteHistory.lastBidId]
-            //if (quoteHistory.avgTopAskPrice - float64(quoteHistory.minTopAskPrice))  > quoteHistory.avgTopAskPrice*0.1 {
-            if data.Positions[data.Stocks[0]].Owned < 500 && !lastBidOrder.Open {
-                ok, id, filled := place_order(data.Venue, data.Stocks[0], "buy", data.Id, buyQty, buyPrice, "limit")
-                if (ok) {
-                    fmt.Printf("Buy Order sent id:%d price %d filled:%d\n", id, buyPrice, filled)
-                    quoteHistory.lastBidId = id;
-                }
-            }
-            if (lastBidOrder.Open) {
-                tOld, _ := time.Parse(time.RFC3339Nano ,lastBidOrder.Ts)
-                tNow, _ := time.Parse(time.RFC3339Nano ,stockQuoteWs.Quote.QuoteTime)
-                fmt.Printf("Time Elapsed from buy order %s\n", (tNow.Sub(tOld)).String());
-                if tNow.Sub(tOld) > time.Duration(20)*time.Second {
-                    ok := cancel_order(data.Venue, lastBidOrder.Symbol, lastBidOrder.Id)
-                    if ok {
-                        fmt.Printf("Canceled Buy Order id:%d \n", lastBidOrder.Id)
-                    }
-                }
-            }
-            //if (float64(quoteHistory.maxTopBidPrice) - quoteHistory.avgTopBidPrice)  > quoteHistory.avgTopBidPrice*0.1 {
-            if  sellPrice >  0 && data.Positions[data.Stocks[0]].Owned > -500 && !lastAskOrder.Open {
-                ok, id, filled := place_order(data.Venue, data.Stocks[0], "sell", data.Id, sellQty, sellPrice, "limit")
-                if (ok) {
-                    fmt.Printf("Sell Order sent id:%d price %d filled:%d\n", id, sellPrice, filled)
-                    quoteHistory.lastAskId = id;
-                }
-            }
-            if (lastAskOrder.Open) {
-                tOld, _ := time.Parse(time.RFC3339Nano ,lastAskOrder.Ts)
-                tNow, _ := time.Parse(time.RFC3339Nano ,stockQuoteWs.Quote.QuoteTime)
-                fmt.Printf("Time Elapsed from buy order %s\n", (tNow.Sub(tOld)).String());
-                if tNow.Sub(tOld) > time.Duration(20)*time.Second {
-                    ok := cancel_order(data.Venue, lastAskOrder.Symbol, lastAskOrder.Id)
-                    if ok {
-                        fmt.Printf("Canceled Buy Order id:%d \n", lastAskOrder.Id)
-                    }
-                }
-            }
-            // }
-        }
+func update_quotes_ws(message api.StockQuoteWs, now time.Time) *Market {
+    market := session.Market(message.Quote.Venue, message.Quote.Symbol)
+    if market == nil {
+        return nil
     }
+    quoteHistory := market.Quotes
+    quoteHistory.last = message.Quote
+    quoteHistory.received = now
+    quoteHistory.history = append( quoteHistory.history,message )
+
+    ts := time.Now()
+    update_quote_history(quoteHistory)
+    mark_position(market, now)
+    profiling.Samples += 1
+    profiling.Executions += 1
+    te:= time.Now()
+    duration := te.Sub(ts)
+    profiling.ExecutionTime = duration
+    return market
 }
 
-func init_web_sockets() {
-    origin := "http://localhost/"
-    urlQuote := fmt.Sprintf("wss://api.stockfighter.io/ob/api/ws/%s/venues/%s/tickertape/stocks/%s",data.Id,data.Venue,data.Stocks[0]);
-    wsQuote, errWsQuote := websocket.Dial(urlQuote, "", origin)
-    globals.wsQuote = wsQuote
-    if errWsQuote != nil {
-        log.Fatal(errWsQuote)
-    }
-    urlExecutions:= fmt.Sprintf("wss://api.stockfighter.io/ob/api/ws/%s/venues/%s/executions/stocks/%s",data.Id,data.Venue,data.Stocks[0]);
-    wsExecutions, errWsExecutions := websocket.Dial(urlExecutions, "", origin)
-    globals.wsExecutions= wsExecutions
-    if errWsExecutions!= nil {
-        log.Fatal(errWsExecutions)
+func update_executions_ws(executions api.Executions) (*Market, error) {
+    session.printf("Received executions : %v\n", executions)
+    update_executions(executions)
+    if err := record_execution(executions); err != nil {
+        return nil, err
     }
+    return session.Market(executions.Venue, executions.Symbol), nil
 }
 
-func update_quotes_ws() {
-    for ;; {
-        errWsQuote := websocket.JSON.Receive(globals.wsQuote, &stockQuoteWs)
-        if errWsQuote != nil {
-            log.Fatal(errWsQuote)
+func handle_gap(gap api.Gap) {
+    report_gap(gap)
+    // Fills may have happened while the executions feed was down.
+    for _, feed := range session.executionsFeeds {
+        if feed.Name == gap.Feed {
+            if err := sync_orders(); err != nil {
+                log.Printf("Resync after %s gap: %s", gap.Feed, err)
+            }
         }
-        quoteHistory.history = append( quoteHistory.history,stockQuoteWs )
     }
 }
 
-func update_executions_ws() {
-    for ;; {
-        errWsExecutions := websocket.JSON.Receive(globals.wsExecutions, &executions)
-        if errWsExecutions != nil {
-            log.Fatal(errWsExecutions)
-        }
-        fmt.Printf("Received executions : %v\n", executions)
-        update_executions_and_position()
+// ignore_rejection logs an order the venue or the risk checks turned down
+// and returns nil so the strategy carries on; any other error, a failed
+// cancel, a bad key or being rate limited among them, is returned as is.
+func ignore_rejection(err error) error {
+    if apiErr, ok := err.(*api.Error); ok && apiErr.Rejected() {
+        session.printf("Rejected: %s\n", apiErr)
+        return nil
     }
+    return err
 }
 
 func main() {
-    //Read API key from file
-    PROFILING := true
-    content, err := ioutil.ReadFile("./keyfile.dat")
-    if err != nil {
+    if err := run_command(os.Args[1:]); err != nil {
         log.Fatal(err)
     }
-
-    //Init globals
-    globals.ApiKey = string(content);
-    globals.httpClient = http.Client{}
-
-    //Init game data
-    data.Id = "FMB75081984"
-    data.Venue = "EPOREX"
-    data.Stocks = append(data.Stocks,"SDI")
-    data.Orders = make(map[int]Order)
-    data.Positions = make(map[string]Position)
-
-    interval := 1000
-    get_all_orders(data.Id,data.Venue, data.Stocks[0])
-
-    time.Sleep(time.Duration(interval) * time.Millisecond)
-    init_web_sockets()
-
-    go update_quotes_ws()
-    go update_executions_ws()
-
-    counter:=0;
-
-    for ;; {
-
-        counter +=1
-        fmt.Printf("Tick %d \n",counter)
-        if PROFILING {
-            fmt.Printf ("Execution of function %s has taken %s and it has been executed %d times\n",
-            "update_quotes",
-            profiling.ExecutionTime.String(),
-            profiling.Executions)
-        }
-
-        data.Positions = make(map[string]Position)
-        get_all_orders(data.Id,data.Venue, data.Stocks[0])
-
-        show_position()
-
-        //Execute strategy
-        update_quotes()
-        if quoteHistory.ready {
-            execute_strategy("level4");
-            //execute_strategy("buy");
-        }
-        time.Sleep(time.Duration(interval) * time.Millisecond)
-
-    }
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+    "database/sql"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// The account's database records every order, every fill and checkpoints
+// of the positions, so a session can be audited and reconstructed
+// afterwards. Each record is written in a single transaction. Until
+// open_store is called nothing is recorded.
+
+func open_store(account string) error {
+    db, err := initDb(account)
+    if err != nil {
+        return err
+    }
+    repository, err := NewRepository(db)
+    if err != nil {
+        db.Close()
+        return err
+    }
+    globals.store = repository
+    return nil
+}
+
+func close_store() {
+    if globals.store != nil {
+        globals.store.Close()
+        globals.store = nil
+    }
+}
+
+func in_transaction(f func(tx *sql.Tx) error) error {
+    if globals.store == nil {
+        return nil
+    }
+    return globals.store.Transaction(f)
+}
+
+// record_order stores the state of an order together with the fills of it
+// at the given indices, e.g. those a sync applied.
+func record_order(order api.Order, fills []int) error {
+    return in_transaction(func(tx *sql.Tx) error {
+        for _, index := range fills {
+            if err := globals.store.SaveFill(tx, order, index, nil); err != nil {
+                return err
+            }
+        }
+        return globals.store.SaveOrder(tx, order)
+    })
+}
+
+// record_execution stores a fill together with the state of the order it
+// left, so the two never disagree.
+func record_execution(execution api.Executions) error {
+    return in_transaction(func(tx *sql.Tx) error {
+        order := execution.Order
+        if err := globals.store.SaveFill(tx, order, len(order.Fills)-1, &execution); err != nil {
+            return err
+        }
+        return globals.store.SaveOrder(tx, order)
+    })
+}
+
+// record_positions checkpoints the position of every market at once.
+func record_positions(now time.Time) error {
+    return in_transaction(func(tx *sql.Tx) error {
+        for _, instrument := range session.Instruments {
+            market := session.Markets[instrument]
+            err := globals.store.CheckpointPosition(tx, now, instrument, market.Position, market.Valuation)
+            if err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// restore_positions sets the markets' positions to their last checkpoint.
+// Markets never checkpointed are left as they are.
+func restore_positions() error {
+    if globals.store == nil {
+        return nil
+    }
+    positions, err := globals.store.LoadPositions()
+    if err != nil {
+        return err
+    }
+    for instrument, position := range positions {
+        if market, ok := session.Markets[instrument]; ok {
+            market.Position = position
+        }
+    }
+    return nil
+}
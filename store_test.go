@@ -0,0 +1,67 @@
+package main
+
+import (
+    "database/sql"
+    "os"
+    "testing"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// in_store runs the test from a fresh database of account.
+func in_store(t *testing.T, account string) {
+    dir, err := os.Getwd()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Chdir(t.TempDir()); err != nil {
+        t.Fatal(err)
+    }
+    if err := open_store(account); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() {
+        close_store()
+        os.Chdir(dir)
+    })
+}
+
+// Fills a sync finds are recorded, and get the ids of the orders they
+// traded between once their execution shows up.
+func TestRecordSyncedFills(t *testing.T) {
+    in_store(t, "ME")
+    _, order := sweep(t)
+    if err := record_order(order, update_order(order)); err != nil {
+        t.Fatal(err)
+    }
+
+    last := len(order.Fills) - 1
+    fill := order.Fills[last]
+    execution := api.Executions{Account: "ME", Venue: "TESTEX", Symbol: "FOO", Order: order,
+        StandingId: 2, IncomingId: order.Id, Price: fill.Price, Filled: fill.Qty, FilledAt: fill.Ts}
+    update_executions(execution)
+    if err := record_execution(execution); err != nil {
+        t.Fatal(err)
+    }
+
+    rows, err := globals.store.db.Query(`SELECT fill_index, standing_id, qty FROM fills WHERE order_id = ? ORDER BY fill_index;`, order.Id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer rows.Close()
+    var recorded []string
+    for rows.Next() {
+        var index, qty int
+        var standingId sql.NullInt64
+        if err := rows.Scan(&index, &standingId, &qty); err != nil {
+            t.Fatal(err)
+        }
+        if qty != 50 || standingId.Valid != (index == last) {
+            t.Fatalf("fill %d: qty %d, standing id %v", index, qty, standingId)
+        }
+        recorded = append(recorded, "")
+    }
+    if len(recorded) != 2 {
+        t.Fatalf("expected 2 fills recorded, got %d", len(recorded))
+    }
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "sort"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// Strategy trades the session from market events. Its settings live in a
+// config struct, returned by Config so they can be loaded from JSON.
+type Strategy interface {
+    Handler
+    // Init is called once the session is set up, before the first event.
+    Init(ctx *Context) error
+    Config() interface{}
+}
+
+// BookPoller is implemented by strategies that price off the full order
+// book, which the engine then fetches on every poll.
+type BookPoller interface {
+    PollBooks() bool
+}
+
+// Stateful is implemented by strategies whose state must survive a restart,
+// such as the ids of their working orders. The engine saves the state as
+// soon as an event changed it and when it stops, and restores it right
+// after Init.
+type Stateful interface {
+    SaveState() ([]byte, error)
+    RestoreState(state []byte) error
+}
+
+// quoteState is what a strategy quoting one bid and one ask on the primary
+// market needs to find its working orders again after a restart.
+type quoteState struct {
+    Venue     string `json:"venue"`
+    LastBidId int    `json:"lastBidId"`
+    LastAskId int    `json:"lastAskId"`
+}
+
+func save_quotes(ctx *Context, bidId int, askId int) ([]byte, error) {
+    return json.Marshal(quoteState{ctx.Primary().Venue, bidId, askId})
+}
+
+// restore_quotes sets the ids of the saved bid and ask, forgetting those
+// unknown to the venue, e.g. when the level was restarted in between.
+func restore_quotes(name string, ctx *Context, data []byte, bidId *int, askId *int) error {
+    var state quoteState
+    if err := json.Unmarshal(data, &state); err != nil {
+        return err
+    }
+    if state.Venue != ctx.Primary().Venue {
+        log.Printf("%s state is for venue %s, ignoring it", name, state.Venue)
+        return nil
+    }
+    for _, restored := range []struct {
+        id   int
+        last *int
+    }{{state.LastBidId, bidId}, {state.LastAskId, askId}} {
+        if _, ok := ctx.Order(state.Venue, restored.id); ok {
+            *restored.last = restored.id
+        } else if restored.id != 0 {
+            log.Printf("%s working order %d unknown to the venue, forgetting it", name, restored.id)
+        }
+    }
+    return nil
+}
+
+// OrderGateway is the only way strategies reach the venue.
+type OrderGateway interface {
+    Place(market *Market, direction api.Direction, qty int, price int, orderType api.OrderType) (api.Order, error)
+    Cancel(order api.Order) error
+    // Amend replaces a working order with one for qty shares at price,
+    // as one step so the side keeps quoting. See amend_order.
+    Amend(market *Market, order api.Order, qty int, price int) (api.Order, error)
+}
+
+// Context is what a strategy sees of the session.
+type Context struct {
+    Session *Session
+    Orders  OrderGateway
+}
+
+func (c *Context) Primary() *Market {
+    return c.Session.Primary()
+}
+
+func (c *Context) Market(venue string, symbol string) *Market {
+    return c.Session.Market(venue, symbol)
+}
+
+func (c *Context) Order(venue string, id int) (api.Order, bool) {
+    return c.Session.Order(venue, id)
+}
+
+func (c *Context) printf(format string, args ...interface{}) {
+    c.Session.printf(format, args...)
+}
+
+// Managed returns the lifecycle of an order the venue knows by id.
+func (c *Context) Managed(venue string, id int) (*ManagedOrder, bool) {
+    return c.Session.Managed(venue, id)
+}
+
+// Working lists the working orders of market on one side, or both when
+// direction is empty.
+func (c *Context) Working(market *Market, direction api.Direction) []*ManagedOrder {
+    return c.Session.Working(market.Instrument, direction)
+}
+
+// BaseStrategy ignores every event. Strategies embed it and override the
+// callbacks they need.
+type BaseStrategy struct {
+    ctx *Context
+}
+
+func (b *BaseStrategy) Init(ctx *Context) error {
+    b.ctx = ctx
+    return nil
+}
+
+func (b *BaseStrategy) OnQuote(market *Market, quote api.Quote) error {
+    return nil
+}
+
+func (b *BaseStrategy) OnExecution(market *Market, execution api.Executions) error {
+    return nil
+}
+
+func (b *BaseStrategy) OnOrderBook(market *Market, book api.OrderBook) error {
+    return nil
+}
+
+func (b *BaseStrategy) OnTimer(now time.Time) error {
+    return nil
+}
+
+// venue_gateway sends orders straight to the venue.
+type venue_gateway struct{}
+
+func (venue_gateway) Place(market *Market, direction api.Direction, qty int, price int, orderType api.OrderType) (api.Order, error) {
+    return place_order(api.OrderRequest{
+        Account:   session.Account,
+        Venue:     market.Venue,
+        Stock:     market.Symbol,
+        Price:     price,
+        Qty:       qty,
+        Direction: direction,
+        OrderType: orderType,
+    })
+}
+
+func (venue_gateway) Cancel(order api.Order) error {
+    return cancel_order(order.Venue, order.Symbol, order.Id)
+}
+
+func (g venue_gateway) Amend(market *Market, order api.Order, qty int, price int) (api.Order, error) {
+    return amend_order(g, market, order, qty, price)
+}
+
+// amend_order cancels order through gateway and, once the venue confirmed
+// it closed, places the replacement on the same side. The venues have no
+// amend, so shares may fill in between: those filled since order, the
+// strategy's view of it, are taken off qty so the position the strategy
+// aimed for is never exceeded. When nothing is left to place, or the
+// order filled entirely, the closed order is returned instead of a
+// replacement.
+func amend_order(gateway OrderGateway, market *Market, order api.Order, qty int, price int) (api.Order, error) {
+    if err := gateway.Cancel(order); err != nil {
+        return api.Order{}, err
+    }
+    cancelled, _ := session.Order(order.Venue, order.Id)
+    if cancelled.Open {
+        return cancelled, fmt.Errorf("amend order %d on %s: still open after the cancel", order.Id, order.Venue)
+    }
+    if filled := cancelled.TotalFilled - order.TotalFilled; filled > 0 {
+        log.Printf("Amend order %d on %s: %d shares filled before the cancel", order.Id, order.Venue, filled)
+        qty -= filled
+    }
+    if qty <= 0 || cancelled.TotalFilled >= cancelled.OriginalQty {
+        return cancelled, nil
+    }
+
+    replacement, err := gateway.Place(market, order.Direction, qty, price, order.OrderType)
+    if err != nil {
+        return replacement, err
+    }
+    if managed, ok := session.Managed(replacement.Venue, replacement.Id); ok {
+        if replaced, ok := session.Managed(order.Venue, order.Id); ok {
+            managed.Replaces = replaced.ClientId
+        }
+    }
+    return replacement, nil
+}
+
+var strategies = make(map[string]func() Strategy)
+
+// register_strategy makes a strategy selectable by name. new returns the
+// strategy with its default configuration.
+func register_strategy(name string, new func() Strategy) {
+    if _, ok := strategies[name]; ok {
+        panic("strategy registered twice: " + name)
+    }
+    strategies[name] = new
+}
+
+func strategy_names() []string {
+    var names []string
+    for name := range strategies {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// new_strategy builds the named strategy, overriding its defaults with the
+// fields present in the JSON config.
+func new_strategy(name string, config []byte) (Strategy, error) {
+    new, ok := strategies[name]
+    if !ok {
+        return nil, fmt.Errorf("unknown strategy %q, expected one of %v", name, strategy_names())
+    }
+    strategy := new()
+    if len(config) > 0 {
+        if err := json.Unmarshal(config, strategy.Config()); err != nil {
+            return nil, fmt.Errorf("%s config: %v", name, err)
+        }
+    }
+    return strategy, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+type BuyConfig struct {
+    BuyQty  int `json:"buyQty"`
+    SellQty int `json:"sellQty"`
+}
+
+// Buy bids at the average top bid and offers at the average top ask of
+// the order book history, once per polled book.
+type Buy struct {
+    BaseStrategy
+    config BuyConfig
+}
+
+func init() {
+    register_strategy("buy", func() Strategy {
+        return &Buy{config: BuyConfig{
+            BuyQty:  1000,
+            SellQty: 900,
+        }}
+    })
+}
+
+func (s *Buy) Config() interface{} {
+    return &s.config
+}
+
+func (s *Buy) PollBooks() bool {
+    return true
+}
+
+func (s *Buy) OnOrderBook(market *Market, book api.OrderBook) error {
+    if market != s.ctx.Primary() || !market.Quotes.ready {
+        return nil
+    }
+    orderBookHistory := market.Book
+
+    buyPrice:= int(orderBookHistory.avgTopBidPrice)  ;
+    order, err := s.ctx.Orders.Place(market, api.Buy, s.config.BuyQty, buyPrice, api.Limit)
+    if err == nil {
+        s.ctx.printf("Buy Order sent id:%d price %d filled:%d\n", order.Id, buyPrice, order.TotalFilled)
+    } else if err = ignore_rejection(err); err != nil {
+        return err
+    }
+
+    sellPrice:= int(orderBookHistory.avgTopAskPrice) ;
+    order, err = s.ctx.Orders.Place(market, api.Sell, s.config.SellQty, sellPrice, api.Limit)
+    if err == nil {
+        s.ctx.printf("Sell Order sent id:%d price %d filled:%d\n", order.Id, sellPrice, order.TotalFilled)
+    } else if err = ignore_rejection(err); err != nil {
+        return err
+    }
+    return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+type Level4Config struct {
+    Qty int `json:"qty"`
+    // No new bids above, nor asks below minus, this many shares.
+    MaxPosition int `json:"maxPosition"`
+    // Within this inventory the strategy quotes at the edges of the
+    // recent range instead of at the average top of book.
+    EdgePosition int `json:"edgePosition"`
+    // Orders resting longer than this, in quote time, are amended to the
+    // current price.
+    OrderTimeoutSeconds int `json:"orderTimeoutSeconds"`
+}
+
+type Level4 struct {
+    BaseStrategy
+    config Level4Config
+
+    lastBidId int
+    lastAskId int
+}
+
+func init() {
+    register_strategy("level4", func() Strategy {
+        return &Level4{config: Level4Config{
+            Qty:                 100,
+            MaxPosition:         500,
+            EdgePosition:        200,
+            OrderTimeoutSeconds: 20,
+        }}
+    })
+}
+
+func (s *Level4) Config() interface{} {
+    return &s.config
+}
+
+func (s *Level4) SaveState() ([]byte, error) {
+    return save_quotes(s.ctx, s.lastBidId, s.lastAskId)
+}
+
+func (s *Level4) RestoreState(data []byte) error {
+    return restore_quotes("Level4", s.ctx, data, &s.lastBidId, &s.lastAskId)
+}
+
+func (s *Level4) OnQuote(market *Market, quote api.Quote) error {
+    return s.execute(market)
+}
+
+func (s *Level4) OnExecution(market *Market, execution api.Executions) error {
+    return s.execute(market)
+}
+
+func (s *Level4) OnTimer(now time.Time) error {
+    return s.execute(s.ctx.Primary())
+}
+
+// expire amends order to price once it has been resting longer than the
+// timeout, keeping what is left of it. last follows the order working on
+// its side.
+func (s *Level4) expire(market *Market, order api.Order, price int, last *int) error {
+    tOld, _ := time.Parse(time.RFC3339Nano ,order.Ts)
+    tNow, _ := time.Parse(time.RFC3339Nano ,market.Quotes.last.QuoteTime)
+    s.ctx.printf("Time Elapsed from %s order %s\n", order.Direction, (tNow.Sub(tOld)).String());
+    if tNow.Sub(tOld) <= time.Duration(s.config.OrderTimeoutSeconds)*time.Second {
+        return nil
+    }
+    replacement, err := s.ctx.Orders.Amend(market, order, order.Qty, price)
+    if err != nil {
+        return ignore_rejection(err)
+    }
+    s.ctx.printf("Amended %s Order id:%d to id:%d price %d\n", order.Direction, order.Id, replacement.Id, price)
+    *last = replacement.Id
+    return nil
+}
+
+func (s *Level4) execute(market *Market) error {
+    quoteHistory := market.Quotes
+    if market != s.ctx.Primary() || !quoteHistory.ready {
+        return nil
+    }
+    owned := market.Position.Owned
+
+    buyPrice := int(quoteHistory.avgTopBidPrice)
+    sellPrice := int(quoteHistory.avgTopAskPrice)
+    if  owned >= -s.config.EdgePosition {
+        buyPrice = int(quoteHistory.minTopAskPrice)
+    }
+
+    if  owned <= s.config.EdgePosition {
+        sellPrice = int(quoteHistory.maxTopBidPrice)
+    }
+    s.ctx.printf("Buyprice :%d AverageBidPrice :%d SellPrice: %d AverageAskPrice: %d\n",
+    buyPrice, int(quoteHistory.avgTopBidPrice),sellPrice,int(quoteHistory.avgTopAskPrice));
+
+    lastAskOrder, _ := s.ctx.Order(market.Venue, s.lastAskId)
+    lastBidOrder, _ := s.ctx.Order(market.Venue, s.lastBidId)
+
+    if owned < s.config.MaxPosition && !lastBidOrder.Open {
+        order, err := s.ctx.Orders.Place(market, api.Buy, s.config.Qty, buyPrice, api.Limit)
+        if err == nil {
+            s.ctx.printf("Buy Order sent id:%d price %d filled:%d\n", order.Id, buyPrice, order.TotalFilled)
+            s.lastBidId = order.Id
+        } else if err = ignore_rejection(err); err != nil {
+            return err
+        }
+    }
+    if lastBidOrder.Open {
+        if err := s.expire(market, lastBidOrder, buyPrice, &s.lastBidId); err != nil {
+            return err
+        }
+    }
+
+    if sellPrice > 0 && owned > -s.config.MaxPosition && !lastAskOrder.Open {
+        order, err := s.ctx.Orders.Place(market, api.Sell, s.config.Qty, sellPrice, api.Limit)
+        if err == nil {
+            s.ctx.printf("Sell Order sent id:%d price %d filled:%d\n", order.Id, sellPrice, order.TotalFilled)
+            s.lastAskId = order.Id
+        } else if err = ignore_rejection(err); err != nil {
+            return err
+        }
+    }
+    if lastAskOrder.Open {
+        if err := s.expire(market, lastAskOrder, sellPrice, &s.lastAskId); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+    "math"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+type MarketMakerConfig struct {
+    // Quoted size when flat; each side is skewed by half the inventory.
+    Qty int `json:"qty"`
+    // Working orders further than this fraction from the target price are
+    // amended to it.
+    Tolerance float64 `json:"tolerance"`
+}
+
+type MarketMaker struct {
+    BaseStrategy
+    config MarketMakerConfig
+
+    lastBidId int
+    lastAskId int
+}
+
+func init() {
+    register_strategy("marketMaker", func() Strategy {
+        return &MarketMaker{config: MarketMakerConfig{
+            Qty:       100,
+            Tolerance: 0.05,
+        }}
+    })
+}
+
+func (s *MarketMaker) Config() interface{} {
+    return &s.config
+}
+
+func (s *MarketMaker) SaveState() ([]byte, error) {
+    return save_quotes(s.ctx, s.lastBidId, s.lastAskId)
+}
+
+func (s *MarketMaker) RestoreState(data []byte) error {
+    return restore_quotes("MarketMaker", s.ctx, data, &s.lastBidId, &s.lastAskId)
+}
+
+func (s *MarketMaker) OnQuote(market *Market, quote api.Quote) error {
+    return s.execute(market)
+}
+
+func (s *MarketMaker) OnExecution(market *Market, execution api.Executions) error {
+    return s.execute(market)
+}
+
+func (s *MarketMaker) OnTimer(now time.Time) error {
+    return s.execute(s.ctx.Primary())
+}
+
+func (s *MarketMaker) execute(market *Market) error {
+    quoteHistory := market.Quotes
+    if market != s.ctx.Primary() || !quoteHistory.ready {
+        return nil
+    }
+
+    spread := quoteHistory.lastTopAskPrice - quoteHistory.lastTopBidPrice
+    buyPrice := quoteHistory.minTopAskPrice
+
+    sellPrice := quoteHistory.maxTopBidPrice
+
+    s.ctx.printf("Buyprice :%d SellPrice :%d Last Spread :%d\n", buyPrice, sellPrice, spread)
+
+    buyQty :=  s.config.Qty - market.Position.Owned/2
+
+    sellQty := s.config.Qty + market.Position.Owned/2
+
+    if buyPrice >= sellPrice {
+        return nil
+    }
+
+    lastBidOrder, _ := s.ctx.Order(market.Venue, s.lastBidId)
+    s.ctx.printf("lastBidOrder :%d open :%t \n", lastBidOrder.Id,lastBidOrder.Open)
+    if !lastBidOrder.Open {
+        if buyQty > 0 {
+            order, err := s.ctx.Orders.Place(market, api.Buy, buyQty, buyPrice, api.Limit)
+            if err == nil {
+                s.ctx.printf("Buy Order sent id:%d price %d filled:%d\n", order.Id, buyPrice, order.TotalFilled)
+                s.lastBidId = order.Id
+            } else if err = ignore_rejection(err); err != nil {
+                return err
+            }
+        }
+    } else if math.Abs(float64(lastBidOrder.Price - buyPrice)) > float64(buyPrice)*s.config.Tolerance {
+        if err := s.replace(market, lastBidOrder, buyQty, buyPrice, &s.lastBidId); err != nil {
+            return err
+        }
+    }
+
+    lastAskOrder, _ := s.ctx.Order(market.Venue, s.lastAskId)
+    s.ctx.printf("lastAskOrder :%d open :%t \n", lastAskOrder.Id,lastAskOrder.Open)
+    if !lastAskOrder.Open {
+        if sellQty > 0 {
+            order, err := s.ctx.Orders.Place(market, api.Sell, sellQty, sellPrice, api.Limit)
+            if err == nil {
+                s.ctx.printf("Sell Order sent id:%d price %d filled:%d\n", order.Id, sellPrice, order.TotalFilled)
+                s.lastAskId = order.Id
+            } else if err = ignore_rejection(err); err != nil {
+                return err
+            }
+        }
+    } else if math.Abs(float64(lastAskOrder.Price - sellPrice)) > float64(sellPrice)*s.config.Tolerance {
+        if err := s.replace(market, lastAskOrder, sellQty, sellPrice, &s.lastAskId); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// replace amends order to qty shares at price, or only cancels it when
+// the inventory leaves nothing to quote on its side. last follows the
+// order working on the side.
+func (s *MarketMaker) replace(market *Market, order api.Order, qty int, price int, last *int) error {
+    if qty <= 0 {
+        err := s.ctx.Orders.Cancel(order)
+        if err == nil {
+            s.ctx.printf("Canceled %s Order id:%d \n", order.Direction, order.Id)
+        }
+        return ignore_rejection(err)
+    }
+    replacement, err := s.ctx.Orders.Amend(market, order, qty, price)
+    if err != nil {
+        return ignore_rejection(err)
+    }
+    s.ctx.printf("Amended %s Order id:%d to id:%d price %d open:%t\n", order.Direction, order.Id, replacement.Id, price, replacement.Open)
+    *last = replacement.Id
+    return nil
+}
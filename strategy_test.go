@@ -0,0 +1,88 @@
+package main
+
+import (
+    "testing"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+    "github.com/vincenzoauteri/stockfighter/mock"
+)
+
+// resting has our account bid for 100 shares at 100 on the mock, and
+// returns the exchange and the order as the strategy sees it.
+func resting(t *testing.T) (*mock.Exchange, *Market, api.Order) {
+    in_store(t, "ME")
+    exchange := mock.NewExchange()
+    exchange.AddVenue("TESTEX", "FOO")
+    server := mock.NewServer(exchange)
+    t.Cleanup(server.Close)
+    globals.client = server.Client("ME")
+    t.Cleanup(func() { globals.client = nil })
+
+    session = Session{Account: "ME", Instruments: []Instrument{{"TESTEX", "FOO"}}, Mark: MARK_LAST}
+    if err := init_session(); err != nil {
+        t.Fatal(err)
+    }
+    market := session.Primary()
+    order, err := venue_gateway{}.Place(market, api.Buy, 100, 100, api.Limit)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !order.Open || order.TotalFilled != 0 {
+        t.Fatalf("expected the bid resting, got %+v", order)
+    }
+    return exchange, market, order
+}
+
+// sell has another trader sell qty shares into our bid.
+func sell(t *testing.T, exchange *mock.Exchange, qty int) {
+    if _, err := exchange.PlaceOrder(api.OrderRequest{Account: "OTHER", Venue: "TESTEX", Stock: "FOO",
+        Price: 100, Qty: qty, Direction: api.Sell, OrderType: api.Limit}); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// Shares filled after the strategy last saw the order are taken off the
+// replacement.
+func TestAmendAfterPartialFill(t *testing.T) {
+    exchange, market, order := resting(t)
+    sell(t, exchange, 30)
+
+    replacement, err := venue_gateway{}.Amend(market, order, 100, 101)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if replacement.Id == order.Id || !replacement.Open || replacement.OriginalQty != 70 || replacement.Price != 101 {
+        t.Fatalf("expected a bid for 70 shares at 101, got %+v", replacement)
+    }
+    cancelled, _ := session.Order("TESTEX", order.Id)
+    if cancelled.Open || cancelled.TotalFilled != 30 {
+        t.Fatalf("expected the bid closed with 30 filled, got %+v", cancelled)
+    }
+    replaced, _ := session.Managed("TESTEX", order.Id)
+    if managed, _ := session.Managed("TESTEX", replacement.Id); managed.Replaces != replaced.ClientId {
+        t.Fatalf("expected order %d to replace order %d", replacement.Id, order.Id)
+    }
+    check_position(t, market, 30, -3000)
+}
+
+// The cancel arrives once the order filled: there is nothing to replace.
+func TestAmendAfterFill(t *testing.T) {
+    exchange, market, order := resting(t)
+    sell(t, exchange, 100)
+
+    closed, err := venue_gateway{}.Amend(market, order, 100, 101)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if closed.Id != order.Id || closed.Open || closed.TotalFilled != 100 {
+        t.Fatalf("expected the filled bid back, got %+v", closed)
+    }
+    orders, err := exchange.AllOrders("ME", "TESTEX", "FOO")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(orders) != 1 {
+        t.Fatalf("expected no replacement placed, got %d orders", len(orders))
+    }
+    check_position(t, market, 100, -10000)
+}
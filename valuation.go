@@ -0,0 +1,137 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// Marks a position can be valued at.
+const (
+    // The price of the last trade.
+    MARK_LAST = "last"
+    // The middle of the top of book.
+    MARK_MID = "mid"
+    // The side of the book the position would be closed against: the bid
+    // for a long position, the ask for a short one.
+    MARK_CONSERVATIVE = "conservative"
+)
+
+// marks price a position of owned shares from a quote, 0 when the quote
+// lacks what the mark needs.
+var marks = map[string]func(quote api.Quote, owned int) int{
+    MARK_LAST: func(quote api.Quote, owned int) int {
+        return quote.Last
+    },
+    MARK_MID: mid,
+    MARK_CONSERVATIVE: func(quote api.Quote, owned int) int {
+        switch {
+        case owned > 0:
+            return quote.Bid
+        case owned < 0:
+            return quote.Ask
+        }
+        return mid(quote, owned)
+    },
+}
+
+func mid(quote api.Quote, owned int) int {
+    if quote.Bid == 0 || quote.Ask == 0 {
+        return 0
+    }
+    return (quote.Bid + quote.Ask) / 2
+}
+
+func check_mark(name string) error {
+    if _, ok := marks[name]; !ok {
+        return fmt.Errorf("unknown mark %q, expected last, mid or conservative", name)
+    }
+    return nil
+}
+
+// Valuation is the value of a position at a time, and the quote it was
+// marked from.
+type Valuation struct {
+    Time time.Time
+    // Mark used, last when the quote lacked what the session's mark needs.
+    Mark  string
+    Price int
+    // Age of the quote at Time, and whether it was older than the
+    // session's StaleQuote.
+    QuoteAge time.Duration
+    Stale    bool
+
+    NAV        int
+    Unrealised int
+}
+
+// NavPoint is the NAV of every position of the session at a time.
+type NavPoint struct {
+    Time time.Time
+    NAV  int
+    // Some position was marked from a stale quote.
+    Stale bool
+}
+
+// quote_age is how long before now the last quote of quotes was received.
+// The venue's quote time is not used: should its clock run ahead of ours,
+// every quote would look fresh.
+func quote_age(quotes *QuoteHistory, now time.Time) time.Duration {
+    if quotes.received.IsZero() || now.Before(quotes.received) {
+        return 0
+    }
+    return now.Sub(quotes.received)
+}
+
+// mark_position values the position of market at now with the session's
+// mark. Without a price to mark at yet, the previous one is kept.
+func mark_position(market *Market, now time.Time) {
+    pos := &market.Position
+    quote := market.Quotes.last
+    mark := session.Mark
+    if mark == "" {
+        mark = MARK_LAST
+    }
+    price := marks[mark](quote, pos.Owned)
+    if price == 0 {
+        mark, price = MARK_LAST, quote.Last
+    }
+    previous := market.Valuation
+    if price == 0 {
+        mark, price = previous.Mark, previous.Price
+    }
+
+    pos.NAV = pos.Balance + pos.Owned*price
+    pos.Unrealised = 0
+    if price != 0 {
+        pos.Unrealised = int(float64(pos.Owned) * (float64(price) - pos.AvgCost))
+    }
+
+    valuation := Valuation{
+        Time:       now,
+        Mark:       mark,
+        Price:      price,
+        QuoteAge:   quote_age(market.Quotes, now),
+        NAV:        pos.NAV,
+        Unrealised: pos.Unrealised,
+    }
+    valuation.Stale = session.StaleQuote > 0 && valuation.QuoteAge > session.StaleQuote
+    if valuation.Stale && !previous.Stale {
+        log.Printf("%s marked at %.2f from a quote %s old", market.Instrument, float64(price)/100.0, valuation.QuoteAge)
+    }
+    market.Valuation = valuation
+}
+
+// value_positions marks every position at now and returns their NAV.
+func value_positions(now time.Time) NavPoint {
+    point := NavPoint{Time: now}
+    for _, instrument := range session.Instruments {
+        market := session.Markets[instrument]
+        mark_position(market, now)
+        point.NAV += market.Position.NAV
+        point.Stale = point.Stale || market.Valuation.Stale
+    }
+    return point
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/vincenzoauteri/stockfighter/api"
+)
+
+// A venue clock running ahead of ours does not keep an old quote fresh.
+func TestStaleQuoteAheadOfOurClock(t *testing.T) {
+    session = Session{Account: "ME", Instruments: []Instrument{{"TESTEX", "FOO"}}, Mark: MARK_LAST,
+        StaleQuote: 5 * time.Second}
+    if err := init_session(); err != nil {
+        t.Fatal(err)
+    }
+    received := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+    quote := api.Quote{Venue: "TESTEX", Symbol: "FOO", Bid: 100, Ask: 110, Last: 105,
+        QuoteTime: received.Add(time.Hour).Format(time.RFC3339Nano)}
+    market := update_quotes_ws(api.StockQuoteWs{Ok: true, Quote: quote}, received)
+    if market == nil || market.Valuation.Stale {
+        t.Fatalf("expected a fresh quote, got %+v", market)
+    }
+
+    value_positions(received.Add(10 * time.Second))
+    if valuation := market.Valuation; !valuation.Stale || valuation.QuoteAge != 10*time.Second {
+        t.Fatalf("expected a stale quote 10s old, got %+v", valuation)
+    }
+}